@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Max size of admin request bodies
+const adminMaxBodyBytes = 1 << 20
+
+// authorizeAdmin checks the "Authorization: Bearer <token>" header against the configured admin token.
+// Responds with an error if the admin API is disabled or the token is invalid.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if conf.AdminToken == "" {
+		respondError(w, "Admin API is disabled", err501)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(conf.AdminToken)) != 1 {
+		respondError(w, "", err401)
+		return false
+	}
+	return true
+}
+
+// adminMarksHandler manages the annotations displayed as chart marks.
+// Requires the admin token in the "Authorization: Bearer <token>" header.
+//
+//	GET    /admin/marks          list all annotations
+//	GET    /admin/marks?id=ID    get an annotation
+//	POST   /admin/marks          create an annotation. Body: Annotation JSON
+//	PUT    /admin/marks?id=ID    replace an annotation. Body: Annotation JSON
+//	DELETE /admin/marks?id=ID    delete an annotation
+func adminMarksHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && id == "" {
+		respondError(w, "id is required", err400)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		annotations, err := store.Annotations()
+		if respondIfError(err, w, "Failed to read annotations", err500) {
+			return
+		}
+		if id == "" {
+			respondJSON(w, annotations, ok200)
+			return
+		}
+		for _, a := range annotations {
+			if a.ID == id {
+				respondJSON(w, a, ok200)
+				return
+			}
+		}
+		respondError(w, "", err404)
+	case http.MethodPost, http.MethodPut:
+		a := Annotation{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminMaxBodyBytes)).Decode(&a)
+		if respondIfError(err, w, "Invalid JSON", err400) {
+			return
+		}
+		if err = a.Validate(); err != nil {
+			respondError(w, err.Error(), err400)
+			return
+		}
+		statusCode := ok200
+		if r.Method == http.MethodPost {
+			a.ID = newAnnotationID()
+			statusCode = created201
+		} else {
+			annotations, err := store.Annotations()
+			if respondIfError(err, w, "Failed to read annotations", err500) {
+				return
+			}
+			if !containsAnnotation(annotations, id) {
+				respondError(w, "", err404)
+				return
+			}
+			a.ID = id
+		}
+		if err = store.SaveAnnotation(a); err != nil {
+			log.Println("[admin] failed to save annotation", err)
+			respondError(w, "Failed to save annotation", err500)
+			return
+		}
+		respondJSON(w, a, statusCode)
+	case http.MethodDelete:
+		err := store.DeleteAnnotation(id)
+		if os.IsNotExist(err) {
+			respondError(w, "", err404)
+			return
+		}
+		if err != nil {
+			log.Println("[admin] failed to delete annotation", err)
+			respondError(w, "Failed to delete annotation", err500)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		respondError(w, "", err405)
+	}
+}
+
+func containsAnnotation(annotations []Annotation, id string) bool {
+	for _, a := range annotations {
+		if a.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Max number of derived bar series kept in memory
+const derivedCacheSize = 64
+
+var derivedBars = newDerivedCache(derivedCacheSize)
+
+// baseResolution finds the largest stored resolution of which the given resolution is a multiple.
+// Eg: "120" can be derived from "60" and "2D" from "1440".
+// Daily and larger resolutions are aligned to the calendar of loc.
+func baseResolution(resolution string, loc *time.Location) (base string, found bool) {
+	target, err := parseResolution(resolution)
+	if err != nil {
+		return
+	}
+	target = target.In(loc)
+	baseMins := 0
+	for i, res := range resolutions {
+		r := resolutionSpecs[i].In(loc)
+		if target.CanDeriveFrom(r) && r.Minutes() > baseMins {
+			base = res
+			baseMins = r.Minutes()
+		}
+	}
+	return base, baseMins > 0
+}
+
+// isAvailableResolution checks if resolution is stored or can be derived from a stored resolution
+// of a symbol in the timezone loc
+func isAvailableResolution(resolution string, loc *time.Location) bool {
+	if isSupportedResolution(resolution) {
+		return true
+	}
+	_, found := baseResolution(resolution, loc)
+	return found
+}
+
+// getDerivedResolution returns all bars of a resolution by aggregating the bars of it's base resolution.
+// Derived bars are cached until the base bars change.
+func getDerivedResolution(ticker, resolution string) (bars []Bar, err error) {
+	loc := symbolLocation(ticker)
+	base, found := baseResolution(resolution, loc)
+	if !found {
+		return nil, nil
+	}
+	baseBars, err := getResolution(ticker, base)
+	if err != nil {
+		return
+	}
+	key := barCacheKey(ticker, resolution)
+	if bars, found := derivedBars.Get(key, baseBars); found {
+		return bars, nil
+	}
+	target, _ := parseResolution(resolution)
+	target = target.In(loc)
+	bars = aggregateBars(baseBars, target)
+	if symbol, _ := symbols.Get(ticker); symbol.FillsGaps(resolution) {
+		bars = fillGaps(nil, bars, target, clock.Now())
+	}
+	derivedBars.Add(key, bars, baseBars)
+	return
+}
+
+// aggregateBars combines bars into bars of a larger resolution.
+// Prices of bars without trades (filled gaps) are ignored, unless none of the combined bars have trades.
+// Expects bars to be in ascending order.
+func aggregateBars(bars []Bar, res Resolution) (result []Bar) {
+	for _, b := range bars {
+		start := res.Start(b.Time)
+		n := len(result)
+		if n == 0 || !result[n-1].Time.Equal(start) {
+			result = append(result, Bar{
+				Time:         start,
+				TimeEnd:      res.End(start),
+				UnixTime:     start.Unix(),
+				OpeningPrice: b.OpeningPrice,
+				HighPrice:    b.HighPrice,
+				LowPrice:     b.LowPrice,
+				ClosingPrice: b.ClosingPrice,
+				Volume:       b.Volume,
+				QuoteVolume:  b.QuoteVolume,
+				Trades:       b.Trades,
+				BuyVolume:    b.BuyVolume,
+				SellVolume:   b.SellVolume,
+				VWAP:         b.VWAP,
+			})
+			continue
+		}
+		bar := &result[n-1]
+		switch {
+		case b.Trades == 0 && bar.Trades > 0:
+			// flat bar filled in a gap. Prices are set by the trades.
+		case bar.Trades == 0 && b.Trades > 0:
+			// only flat bars so far. Start with the prices of the first trades.
+			bar.OpeningPrice = b.OpeningPrice
+			bar.HighPrice = b.HighPrice
+			bar.LowPrice = b.LowPrice
+			bar.ClosingPrice = b.ClosingPrice
+		default:
+			if b.HighPrice > bar.HighPrice {
+				bar.HighPrice = b.HighPrice
+			}
+			if b.LowPrice < bar.LowPrice {
+				bar.LowPrice = b.LowPrice
+			}
+			bar.ClosingPrice = b.ClosingPrice
+		}
+		bar.Volume += b.Volume
+		bar.QuoteVolume += b.QuoteVolume
+		bar.Trades += b.Trades
+		bar.BuyVolume += b.BuyVolume
+		bar.SellVolume += b.SellVolume
+		bar.setVWAP()
+	}
+	return
+}
+
+// derivedCache is a least recently used cache of derived bars.
+// Each entry is only valid as long as all of the bars it was derived from remain the same.
+type derivedCache struct {
+	mutex    sync.Mutex
+	capacity int
+	order    *list.List // most recently used first
+	items    map[string]*list.Element
+}
+
+type derivedEntry struct {
+	key   string
+	bases [][]Bar
+	bars  []Bar
+}
+
+func newDerivedCache(capacity int) *derivedCache {
+	return &derivedCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// sameBars checks if both are the same snapshot of bars
+func sameBars(a, b []Bar) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// Get returns the derived bars if they were derived from bases
+func (c *derivedCache) Get(key string, bases ...[]Bar) (bars []Bar, found bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	item, exists := c.items[key]
+	if !exists {
+		return
+	}
+	entry := item.Value.(*derivedEntry)
+	outdated := len(entry.bases) != len(bases)
+	for i := 0; !outdated && i < len(bases); i++ {
+		outdated = !sameBars(entry.bases[i], bases[i])
+	}
+	if outdated {
+		c.order.Remove(item)
+		delete(c.items, key)
+		return
+	}
+	c.order.MoveToFront(item)
+	return entry.bars, true
+}
+
+// Add caches the bars derived from bases. Removes the least recently used entry if full.
+func (c *derivedCache) Add(key string, bars []Bar, bases ...[]Bar) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if item, exists := c.items[key]; exists {
+		c.order.Remove(item)
+	}
+	c.items[key] = c.order.PushFront(&derivedEntry{key: key, bases: bases, bars: bars})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*derivedEntry).key)
+	}
+}
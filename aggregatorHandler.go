@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// Trade types of the aggregator API
+const tradeTypeBuy = "buy"
+const tradeTypeSell = "sell"
+
+// Default and max number of items per page
+const aggregatorDefaultLimit = 500
+const aggregatorMaxLimit = 5000
+
+// Aggregator API for market data aggregators such as CoinGecko and CoinMarketCap.
+// Field names follow the CoinGecko exchange API standard, where "base" is the traded token
+// and "target" the token it is priced in. Eg: ticker "HALO_ETH" has base "HALO" and target "ETH".
+// Note: this is the reverse of the Market's QuoteTicker and BaseTicker.
+//
+// Only symbols of the first trade source are listed, unless "exchange" param is set.
+
+// AggregatorPair describes a tradable pair
+type AggregatorPair struct {
+	TickerID string `json:"ticker_id"`
+	Base     string `json:"base"`
+	Target   string `json:"target"`
+}
+
+// AggregatorTicker describes the 24 hour statistics of a pair
+type AggregatorTicker struct {
+	TickerID       string  `json:"ticker_id"`
+	BaseCurrency   string  `json:"base_currency"`
+	TargetCurrency string  `json:"target_currency"`
+	LastPrice      float64 `json:"last_price"`
+	BaseVolume     float64 `json:"base_volume"`
+	TargetVolume   float64 `json:"target_volume"`
+	Bid            float64 `json:"bid"`
+	Ask            float64 `json:"ask"`
+	High           float64 `json:"high"`
+	Low            float64 `json:"low"`
+}
+
+// AggregatorOrderBook contains the open orders of a pair as ["price", "amount"] tuples
+type AggregatorOrderBook struct {
+	TickerID  string      `json:"ticker_id"`
+	Timestamp int64       `json:"timestamp"` // Unix Epoch milliseconds
+	Bids      [][2]string `json:"bids"`
+	Asks      [][2]string `json:"asks"`
+}
+
+// AggregatorTrade describes a single trade
+type AggregatorTrade struct {
+	// Unique within the pair. Derived from the trade time.
+	TradeID        string  `json:"trade_id"`
+	Price          float64 `json:"price"`
+	BaseVolume     float64 `json:"base_volume"`
+	TargetVolume   float64 `json:"target_volume"`
+	TradeTimestamp int64   `json:"trade_timestamp"` // Unix Epoch milliseconds
+	// buy or sell. Inferred by comparing the price with the previous trade (tick rule).
+	Type string `json:"type"`
+}
+
+// aggregatorTickerID converts symbol name to ticker ID. Eg: "HALO/ETH" => "HALO_ETH"
+func aggregatorTickerID(symbol Symbol) string {
+	return strings.Replace(symbol.Name, "/", "_", 1)
+}
+
+// aggregatorSymbols returns the symbols of the exchange. Default: the first trade source.
+func aggregatorSymbols(exchange string) (list []Symbol) {
+	if exchange == "" && len(sourceNames) > 0 {
+		exchange = sourceNames[0]
+	}
+	for _, s := range symbols.List() {
+		if strings.EqualFold(s.Exchange, exchange) {
+			list = append(list, s)
+		}
+	}
+	return
+}
+
+// TickerID returns the symbol of the "ticker_id" param within the exchange of the "exchange" param
+func (p *requestParams) TickerID(name string) (symbol Symbol) {
+	tickerID := p.String(name, true)
+	exchange := p.String("exchange", false)
+	if !p.Valid() {
+		return
+	}
+	for _, s := range aggregatorSymbols(exchange) {
+		if strings.EqualFold(aggregatorTickerID(s), tickerID) {
+			return s
+		}
+	}
+	p.Invalidate("unknown ticker_id", err404)
+	return
+}
+
+// Page returns the "offset" and "limit" params
+func (p *requestParams) Page() (offset, limit int) {
+	offset = p.Int("offset", false)
+	limit = p.Int("limit", false)
+	if limit == 0 {
+		limit = aggregatorDefaultLimit
+	}
+	if limit > aggregatorMaxLimit {
+		p.Invalidate(fmt.Sprintf("limit must not exceed %d", aggregatorMaxLimit), err400)
+	}
+	return
+}
+
+// paginate returns the slice range of the page
+func paginate(total, offset, limit int) (start, end int) {
+	if offset > total {
+		offset = total
+	}
+	end = offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
+
+// aggregatorPairsHandler responds with all tradable pairs
+// GET Params:
+// @exchange (optional)
+// @offset   (optional)
+// @limit    (optional) default 500
+func aggregatorPairsHandler(w http.ResponseWriter, r *http.Request) {
+	params := newRequestParams(r)
+	exchange := params.String("exchange", false)
+	offset, limit := params.Page()
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	list := aggregatorSymbols(exchange)
+	start, end := paginate(len(list), offset, limit)
+	pairs := []AggregatorPair{}
+	for _, s := range list[start:end] {
+		m := s.market()
+		pairs = append(pairs, AggregatorPair{
+			TickerID: aggregatorTickerID(s),
+			Base:     m.QuoteTicker,
+			Target:   m.BaseTicker,
+		})
+	}
+	respondJSON(w, pairs, ok200)
+}
+
+// aggregatorTickersHandler responds with the 24 hour statistics of all pairs
+// GET Params:
+// @exchange (optional)
+// @offset   (optional)
+// @limit    (optional) default 500
+func aggregatorTickersHandler(w http.ResponseWriter, r *http.Request) {
+	params := newRequestParams(r)
+	exchange := params.String("exchange", false)
+	offset, limit := params.Page()
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	list := aggregatorSymbols(exchange)
+	start, end := paginate(len(list), offset, limit)
+	tickers := []AggregatorTicker{}
+	for _, s := range list[start:end] {
+		m := s.market()
+		ticker := AggregatorTicker{
+			TickerID:       aggregatorTickerID(s),
+			BaseCurrency:   m.QuoteTicker,
+			TargetCurrency: m.BaseTicker,
+		}
+		q, err := getQuoteValues(s)
+		if err != nil {
+			log.Println("[tickers] failed to calculate quote", s.Ticker, err)
+			respondError(w, "Failed to read trades", err500)
+			return
+		}
+		if q != nil {
+			ticker.LastPrice = q.LastPrice
+			ticker.BaseVolume = q.Volume
+			ticker.TargetVolume = q.baseVolume
+			ticker.Bid = q.Bid
+			ticker.Ask = q.Ask
+			ticker.High = q.HighPrice
+			ticker.Low = q.LowPrice
+		}
+		tickers = append(tickers, ticker)
+	}
+	respondJSON(w, tickers, ok200)
+}
+
+// aggregatorOrderBookHandler responds with the order book of a pair.
+// Only available if the trade source provides the order book.
+// GET Params:
+// @ticker_id Eg: "HALO_ETH"
+// @depth     (optional) total number of orders. Half on each side. Default 0: all
+// @exchange  (optional)
+func aggregatorOrderBookHandler(w http.ResponseWriter, r *http.Request) {
+	params := newRequestParams(r)
+	symbol := params.TickerID("ticker_id")
+	depth := params.Int("depth", false)
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	source, _ := getSource(symbol.Exchange)
+	books, ok := source.(OrderBookSource)
+	if !ok {
+		respondError(w, "Order book is not available", err501)
+		return
+	}
+	book, err := books.FetchOrderBook(symbol.market(), (depth+1)/2)
+	if err != nil {
+		log.Println("[orderbook] failed to retrieve order book", symbol.Ticker, err)
+		respondError(w, "Failed to retrieve order book", err500)
+		return
+	}
+	result := AggregatorOrderBook{
+		TickerID:  aggregatorTickerID(symbol),
+		Timestamp: clock.Now().UnixNano() / int64(time.Millisecond),
+		Bids:      [][2]string{},
+		Asks:      [][2]string{},
+	}
+	for _, o := range book.Bids {
+		result.Bids = append(result.Bids, [2]string{formatDecimal(o.Price), formatDecimal(o.Amount)})
+	}
+	for _, o := range book.Asks {
+		result.Asks = append(result.Asks, [2]string{formatDecimal(o.Price), formatDecimal(o.Amount)})
+	}
+	respondJSON(w, result, ok200)
+}
+
+// aggregatorTradesHandler responds with the trades of a pair by type ("buy" and "sell"), most recent first
+// GET Params:
+// @ticker_id  Eg: "HALO_ETH"
+// @type       (optional) buy or sell
+// @start_time (optional) Unix Epoch milliseconds, inclusive
+// @end_time   (optional) Unix Epoch milliseconds, inclusive
+// @offset     (optional)
+// @limit      (optional) default 500
+// @exchange   (optional)
+func aggregatorTradesHandler(w http.ResponseWriter, r *http.Request) {
+	params := newRequestParams(r)
+	symbol := params.TickerID("ticker_id")
+	tradeType := strings.ToLower(params.String("type", false))
+	startTime := params.Int64("start_time", false)
+	endTime := params.Int64("end_time", false)
+	offset, limit := params.Page()
+	if params.Valid() && tradeType != "" && tradeType != tradeTypeBuy && tradeType != tradeTypeSell {
+		params.Invalidate("type must be buy or sell", err400)
+	}
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	start := time.Unix(0, startTime*int64(time.Millisecond))
+	end := clock.Now()
+	if endTime > 0 {
+		end = time.Unix(0, endTime*int64(time.Millisecond))
+	}
+	// Only the latest trades needed for the page are read.
+	// More are read if not enough of them are of the requested type.
+	var trades []AggregatorTrade
+	for count := offset + limit; ; count *= 2 {
+		all, complete, err := readAggregatorTrades(symbol, start, end, count)
+		if err != nil {
+			log.Println("[historical_trades] failed to read trades", symbol.Ticker, err)
+			respondError(w, "Failed to read trades", err500)
+			return
+		}
+		trades = all
+		if tradeType != "" {
+			trades = []AggregatorTrade{}
+			for _, t := range all {
+				if t.Type == tradeType {
+					trades = append(trades, t)
+				}
+			}
+		}
+		if complete || len(trades) >= offset+limit {
+			break
+		}
+	}
+	from, to := paginate(len(trades), offset, limit)
+	result := map[string][]AggregatorTrade{}
+	for _, t := range []string{tradeTypeBuy, tradeTypeSell} {
+		if tradeType == "" || tradeType == t {
+			result[t] = []AggregatorTrade{}
+		}
+	}
+	for _, t := range trades[from:to] {
+		result[t.Type] = append(result[t.Type], t)
+	}
+	respondJSON(w, result, ok200)
+}
+
+// readAggregatorTrades returns the latest trades within the time range (inclusive) in decending order.
+// At least count trades are returned, if available. complete is false if there are more trades within the range.
+// Trades of the bar (of the smallest resolution) before the oldest trade are read to infer the trade types.
+func readAggregatorTrades(symbol Symbol, start, end time.Time, count int) (result []AggregatorTrade, complete bool, err error) {
+	ticker := strings.ToLower(symbol.Ticker)
+	since, err := aggregatorTradesSince(ticker, start, end, count)
+	if err != nil {
+		return
+	}
+	complete = !since.After(start)
+	from, err := contextStart(ticker, since)
+	if err != nil {
+		return
+	}
+	trades, err := store.TradesBetween(ticker, from, end)
+	if err != nil {
+		return
+	}
+	applyCorporateActions(symbol, trades)
+	types := inferTradeTypes(trades)
+	ids := tradeIDs(trades)
+	now := clock.Now()
+	for i, t := range trades {
+		if t.Time.Before(since) {
+			break
+		}
+		if t.Time.After(end) || t.Time.After(now) || t.Time.Before(conf.IgnoreTradesBefore) {
+			continue
+		}
+		result = append(result, AggregatorTrade{
+			TradeID:        ids[i],
+			Price:          t.Price,
+			BaseVolume:     t.Amount,
+			TargetVolume:   t.Price * t.Amount,
+			TradeTimestamp: t.Time.UnixNano() / int64(time.Millisecond),
+			Type:           types[i],
+		})
+	}
+	return
+}
+
+// aggregatorTradesSince returns the start of the bar (of the smallest resolution) since which
+// there are at least count trades on or before end, counted by the bars. Not before start.
+func aggregatorTradesSince(ticker string, start, end time.Time, count int) (time.Time, error) {
+	n := 0
+	for to := end.Unix(); ; {
+		bars, err := store.LastBars(ticker, smallestResolution(), to, 100)
+		if err != nil {
+			return start, err
+		}
+		for i := len(bars) - 1; i >= 0; i-- {
+			if !bars[i].Time.After(start) {
+				return start, nil
+			}
+			if n += bars[i].Trades; n >= count {
+				return bars[i].Time, nil
+			}
+		}
+		if len(bars) < 100 {
+			return start, nil
+		}
+		to = bars[0].UnixTime - 1
+	}
+}
+
+// inferTradeTypes infers whether each trade was a buy or sell using the tick rule:
+// trades at a higher price than the previous trade are buys and lower are sells.
+// Trades at the same price keep the type of the previous trade.
+// Expects trades to be in decending order.
+func inferTradeTypes(trades []client.Trade) []string {
+	types := make([]string, len(trades))
+	tradeType := tradeTypeBuy
+	for i := len(trades) - 1; i >= 0; i-- {
+		if i < len(trades)-1 {
+			prev := trades[i+1].Price
+			if trades[i].Price > prev {
+				tradeType = tradeTypeBuy
+			} else if trades[i].Price < prev {
+				tradeType = tradeTypeSell
+			}
+		}
+		types[i] = tradeType
+	}
+	return types
+}
+
+// tradeIDs generates IDs from the trade time in nanoseconds.
+// Trades with the same time are suffixed by their order. Eg: "1546300800000000000-1"
+// Expects trades to be in decending order.
+func tradeIDs(trades []client.Trade) []string {
+	ids := make([]string, len(trades))
+	seq := 0
+	for i := len(trades) - 1; i >= 0; i-- {
+		if i < len(trades)-1 && trades[i].Time.Equal(trades[i+1].Time) {
+			seq++
+		} else {
+			seq = 0
+		}
+		ids[i] = strconv.FormatInt(trades[i].Time.UnixNano(), 10)
+		if seq > 0 {
+			ids[i] += "-" + strconv.Itoa(seq)
+		}
+	}
+	return ids
+}
+
+// formatDecimal formats number without exponent
+func formatDecimal(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoricalTrades(t *testing.T) {
+	feed := startTestFeed(t)
+	symbol, _ := findSymbol("HALO/ETH")
+	// all trades, with the types inferred from the first trade
+	all, complete, err := readAggregatorTrades(symbol, time.Unix(0, 0), clock.Now(), aggregatorMaxLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete || len(all) != 5 {
+		t.Fatalf("unexpected trades: %v %+v", complete, all)
+	}
+	types := []string{}
+	for _, trade := range all {
+		types = append(types, trade.Type)
+	}
+	if want := []string{"sell", "buy", "sell", "buy", "buy"}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("unexpected types: %v, want: %v", types, want)
+	}
+
+	// pages of the bounded reads must be the same as of all trades
+	for _, tradeType := range []string{"", tradeTypeBuy, tradeTypeSell} {
+		for _, endTime := range []int64{0, 1546304399000, 1546304400000} {
+			for offset := 0; offset <= 5; offset++ {
+				for limit := 1; limit <= 5; limit++ {
+					query := fmt.Sprintf("ticker_id=HALO_ETH&type=%s&offset=%d&limit=%d", tradeType, offset, limit)
+					if endTime > 0 {
+						query += fmt.Sprintf("&end_time=%d", endTime)
+					}
+					want := map[string][]AggregatorTrade{}
+					for _, t := range []string{tradeTypeBuy, tradeTypeSell} {
+						if tradeType == "" || tradeType == t {
+							want[t] = []AggregatorTrade{}
+						}
+					}
+					filtered := []AggregatorTrade{}
+					for _, trade := range all {
+						if (tradeType == "" || trade.Type == tradeType) && (endTime == 0 || trade.TradeTimestamp <= endTime) {
+							filtered = append(filtered, trade)
+						}
+					}
+					from, to := paginate(len(filtered), offset, limit)
+					for _, trade := range filtered[from:to] {
+						want[trade.Type] = append(want[trade.Type], trade)
+					}
+
+					status, body := get(t, feed, "/aggregator/historical_trades?"+query)
+					if status != http.StatusOK {
+						t.Fatalf("%s: unexpected status %d: %s", query, status, body)
+					}
+					got := map[string][]AggregatorTrade{}
+					if err := json.Unmarshal(body, &got); err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("%s: unexpected trades\n got: %+v\nwant: %+v", query, got, want)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Annotation categories
+const (
+	annotationListing      = "listing"
+	annotationSplit        = "split"
+	annotationMigration    = "migration"
+	annotationAnnouncement = "announcement"
+)
+
+// Annotation is a curated chart event displayed as a mark on the bars or on the time scale
+type Annotation struct {
+	ID string `json:"id"`
+	// Symbol names or tickers. Eg: "HALO/ETH". Empty: all symbols
+	Symbols  []string  `json:"symbols"`
+	Time     time.Time `json:"time"`
+	Category string    `json:"category"`
+	// Displayed on hover
+	Text string `json:"text"`
+	// Single character displayed on the mark. Default: first letter of the category
+	Label string `json:"label"`
+	// Name or hex code. Default: "blue"
+	Color string `json:"color"`
+	// Display on the time scale instead of the bars
+	Timescale bool `json:"timescale"`
+	// Only display on these resolutions. Empty: all resolutions
+	Resolutions []string `json:"resolutions"`
+}
+
+// Validate checks the required fields and sets the defaults
+func (a *Annotation) Validate() error {
+	a.Category = strings.ToLower(strings.TrimSpace(a.Category))
+	a.Text = strings.TrimSpace(a.Text)
+	switch {
+	case a.Time.IsZero():
+		return errors.New("time is required")
+	case a.Text == "":
+		return errors.New("text is required")
+	}
+	switch a.Category {
+	case annotationListing, annotationSplit, annotationMigration, annotationAnnouncement:
+	default:
+		return errors.New("category must be one of listing, split, migration or announcement")
+	}
+	if len(a.Label) > 1 {
+		return errors.New("label must be a single character")
+	}
+	if a.Label == "" {
+		a.Label = strings.ToUpper(a.Category[:1])
+	}
+	if a.Color == "" {
+		a.Color = "blue"
+	}
+	a.Time = a.Time.UTC()
+	return nil
+}
+
+// AppliesTo checks if the annotation is displayed on the chart of the symbol and resolution
+func (a Annotation) AppliesTo(symbol Symbol, resolution string) bool {
+	if len(a.Resolutions) > 0 && !containsString(a.Resolutions, resolution) {
+		return false
+	}
+	if len(a.Symbols) == 0 {
+		return true
+	}
+	for _, s := range a.Symbols {
+		if strings.EqualFold(s, symbol.Ticker) || strings.EqualFold(s, symbol.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mark converts annotation to a mark on the bars
+func (a Annotation) Mark() Mark {
+	return Mark{
+		ID:             a.ID,
+		Time:           a.Time.Unix(),
+		Color:          a.Color,
+		Text:           a.Text,
+		Label:          a.Label,
+		LabelFontColor: "white",
+		MinSize:        14,
+	}
+}
+
+// TimescaleMark converts annotation to a mark on the time scale
+func (a Annotation) TimescaleMark() TimescaleMark {
+	return TimescaleMark{
+		ID:      a.ID,
+		Time:    a.Time.Unix(),
+		Color:   a.Color,
+		Label:   a.Label,
+		Tooltip: strings.Split(a.Text, "\n"),
+	}
+}
+
+// findAnnotations returns the stored annotations for the chart within the time range (Unix Epoch seconds, inclusive)
+func findAnnotations(symbol Symbol, resolution string, from, to int64, timescale bool) (result []Annotation, err error) {
+	list, err := store.Annotations()
+	if err != nil {
+		return
+	}
+	for _, a := range list {
+		t := a.Time.Unix()
+		if a.Timescale == timescale && t >= from && t <= to && a.AppliesTo(symbol, resolution) {
+			result = append(result, a)
+		}
+	}
+	return
+}
+
+// newAnnotationID generates a random ID
+func newAnnotationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
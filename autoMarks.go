@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// Default number of previous bars used to calculate the rolling volume mean and standard deviation
+const defaultVolumeWindow = 20
+
+// AutoMarksConfig describes the marks generated automatically from trades and bars.
+// Each type of mark is disabled if it's threshold is not set.
+type AutoMarksConfig struct {
+	// Minimum notional value (price * amount) of whale trades by base token ticker. Eg: {"ETH": 10}
+	WhaleNotional map[string]float64 `json:"whalenotional"`
+	// Mark bars with volume exceeding the rolling mean by this many standard deviations
+	VolumeStdDevs float64 `json:"volumestddevs"`
+	// Number of previous bars used for the rolling mean. Default: 20
+	VolumeWindow int `json:"volumewindow"`
+	// Mark bars opening at least this percent above or below the close of the previous bar
+	GapPercent float64 `json:"gappercent"`
+}
+
+// Enabled checks if any automatic marks are configured
+func (c AutoMarksConfig) Enabled() bool {
+	return len(c.WhaleNotional) > 0 || c.VolumeStdDevs > 0 || c.GapPercent > 0
+}
+
+// whaleThreshold returns the minimum notional value of whale trades of the symbol. Zero if disabled.
+func (c AutoMarksConfig) whaleThreshold(symbol Symbol) float64 {
+	for base, notional := range c.WhaleNotional {
+		if strings.EqualFold(base, symbol.BaseTicker) {
+			return notional
+		}
+	}
+	return 0
+}
+
+var whaleMarks = newWhaleCache()
+
+// whaleCache keeps the whale trade marks of each symbol in memory.
+// Marks are generated from the whole trade log on first use and extended by sync.
+type whaleCache struct {
+	mutex sync.Mutex
+	marks map[string][]Mark    // ticker : marks in ascending order
+	until map[string]time.Time // ticker : time of the latest processed trade
+	seen  map[string]int       // ticker : number of processed trades at the until time
+}
+
+func newWhaleCache() *whaleCache {
+	return &whaleCache{
+		marks: map[string][]Mark{},
+		until: map[string]time.Time{},
+		seen:  map[string]int{},
+	}
+}
+
+// Get returns the whale trade marks of the symbol. Generates them from the stored trades, if not loaded.
+func (c *whaleCache) Get(symbol Symbol) ([]Mark, error) {
+	ticker := strings.ToLower(symbol.Ticker)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if marks, loaded := c.marks[ticker]; loaded {
+		return marks, nil
+	}
+	trades, err := store.TradesSince(ticker, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	applyCorporateActions(symbol, trades)
+	c.marks[ticker] = []Mark{}
+	c.add(symbol, trades)
+	return c.marks[ticker], nil
+}
+
+// Add generates marks from new trades (in decending order), if the marks of the symbol are loaded
+func (c *whaleCache) Add(symbol Symbol, trades []client.Trade) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, loaded := c.marks[strings.ToLower(symbol.Ticker)]; loaded {
+		c.add(symbol, trades)
+	}
+}
+
+// add appends marks of the trades after the latest processed trade. Expects mutex to be locked.
+// Trades with the same time as the latest processed trade are skipped only if already processed,
+// as sync may pass trades that were already loaded by Get.
+// Mark IDs of trades with the same time are suffixed by their order, as done by tradeIDs.
+func (c *whaleCache) add(symbol Symbol, trades []client.Trade) {
+	ticker := strings.ToLower(symbol.Ticker)
+	threshold := conf.AutoMarks.whaleThreshold(symbol)
+	quote := symbol.market().QuoteTicker
+	until, seen := c.until[ticker], c.seen[ticker]
+	skip := seen
+	// Copy to avoid modifying the slice returned by Get
+	marks := append([]Mark{}, c.marks[ticker]...)
+	for i := len(trades) - 1; i >= 0; i-- {
+		t := trades[i]
+		switch {
+		case t.Time.Before(until):
+			continue
+		case t.Time.After(until):
+			until, seen, skip = t.Time, 0, 0
+		case skip > 0:
+			skip--
+			continue
+		}
+		seq := seen
+		seen++
+		notional := t.Price * t.Amount
+		if threshold <= 0 || notional < threshold {
+			continue
+		}
+		id := fmt.Sprintf("whale-%d", t.Time.UnixNano())
+		if seq > 0 {
+			id += fmt.Sprintf("-%d", seq)
+		}
+		marks = append(marks, Mark{
+			ID:    id,
+			Time:  t.Time.Unix(),
+			Color: "purple",
+			Text: fmt.Sprintf("Whale trade: %.8g %s @ %.8g %s (%.8g %s)",
+				t.Amount, quote, t.Price, symbol.BaseTicker, notional, symbol.BaseTicker),
+			Label:          "W",
+			LabelFontColor: "white",
+			MinSize:        14,
+		})
+	}
+	c.marks[ticker] = marks
+	c.until[ticker] = until
+	c.seen[ticker] = seen
+}
+
+// volumeMarks marks bars with volume exceeding the rolling mean of the previous bars
+// by the configured number of standard deviations. Expects bars to be in ascending order.
+func volumeMarks(bars []Bar, resolution string, stdDevs float64, window int) (marks []Mark) {
+	if window <= 0 {
+		window = defaultVolumeWindow
+	}
+	sum, sumSq := 0.0, 0.0
+	for i, bar := range bars {
+		if i >= window {
+			n := float64(window)
+			mean := sum / n
+			stdDev := math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+			if stdDev > 0 && bar.Volume > mean+stdDevs*stdDev {
+				marks = append(marks, Mark{
+					ID:    fmt.Sprintf("volume-%s-%d", resolution, bar.UnixTime),
+					Time:  bar.UnixTime,
+					Color: "orange",
+					Text: fmt.Sprintf("Abnormal volume: %.8g (%.1f standard deviations above average %.8g)",
+						bar.Volume, (bar.Volume-mean)/stdDev, mean),
+					Label:          "V",
+					LabelFontColor: "white",
+					MinSize:        14,
+				})
+			}
+			// remove the oldest bar from the window
+			old := bars[i-window].Volume
+			sum -= old
+			sumSq -= old * old
+		}
+		sum += bar.Volume
+		sumSq += bar.Volume * bar.Volume
+	}
+	return
+}
+
+// gapMarks marks bars opening at least the given percent above or below the close of the previous bar.
+// Expects bars to be in ascending order.
+func gapMarks(bars []Bar, resolution string, percent float64) (marks []Mark) {
+	for i := 1; i < len(bars); i++ {
+		prevClose := bars[i-1].ClosingPrice
+		if prevClose == 0 {
+			continue
+		}
+		gap := (bars[i].OpeningPrice - prevClose) / prevClose * 100
+		if math.Abs(gap) < percent {
+			continue
+		}
+		color := "green"
+		if gap < 0 {
+			color = "red"
+		}
+		marks = append(marks, Mark{
+			ID:             fmt.Sprintf("gap-%s-%d", resolution, bars[i].UnixTime),
+			Time:           bars[i].UnixTime,
+			Color:          color,
+			Text:           fmt.Sprintf("Price gap: %+.2f%% from %.8g to %.8g", gap, prevClose, bars[i].OpeningPrice),
+			Label:          "G",
+			LabelFontColor: "white",
+			MinSize:        14,
+		})
+	}
+	return
+}
+
+// autoMarks returns the automatically generated marks of the chart within the time range (Unix Epoch seconds, inclusive)
+func autoMarks(symbol Symbol, resolution string, from, to int64) (result []Mark, err error) {
+	c := conf.AutoMarks
+	marks := []Mark{}
+	if c.whaleThreshold(symbol) > 0 && symbol.isTraded() {
+		whales, err := whaleMarks.Get(symbol)
+		if err != nil {
+			return nil, err
+		}
+		marks = append(marks, whales...)
+	}
+	if c.VolumeStdDevs > 0 || c.GapPercent > 0 {
+		bars, err := getResolution(strings.ToLower(symbol.Ticker), resolution)
+		if err != nil {
+			return nil, err
+		}
+		if c.VolumeStdDevs > 0 {
+			marks = append(marks, volumeMarks(bars, resolution, c.VolumeStdDevs, c.VolumeWindow)...)
+		}
+		if c.GapPercent > 0 {
+			marks = append(marks, gapMarks(bars, resolution, c.GapPercent)...)
+		}
+	}
+	for _, m := range marks {
+		if m.Time >= from && m.Time <= to {
+			result = append(result, m)
+		}
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+func TestWhaleMarksSameTime(t *testing.T) {
+	startTestFeed(t)
+	conf.AutoMarks = AutoMarksConfig{WhaleNotional: map[string]float64{"ETH": 0.001}}
+	symbol, found := findSymbol("HALO/ETH")
+	if !found {
+		t.Fatal("symbol not found")
+	}
+	t0 := time.Date(2019, 1, 1, 0, 5, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	trade := func(t time.Time, amount float64) client.Trade {
+		return client.Trade{Time: t, Price: 0.0001, Amount: amount}
+	}
+	ids := func(marks []Mark) (result []string) {
+		for _, m := range marks {
+			result = append(result, m.ID)
+		}
+		return
+	}
+
+	c := newWhaleCache()
+	c.marks[strings.ToLower(symbol.Ticker)] = []Mark{}
+	// decending order. The small trade is not a whale, but counts for the order of same time trades.
+	first := []client.Trade{trade(t0, 30), trade(t0, 1), trade(t0, 20)}
+	c.Add(symbol, first)
+	c.Add(symbol, first) // already processed, eg: loaded by Get while syncing
+	c.Add(symbol, []client.Trade{trade(t1, 10), trade(t0, 40), trade(t0, 30), trade(t0, 1), trade(t0, 20)})
+	marks, err := c.Get(symbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"whale-1546301100000000000",
+		"whale-1546301100000000000-2",
+		"whale-1546301100000000000-3",
+		"whale-1546301160000000000",
+	}
+	if got := ids(marks); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected marks\n got: %v\nwant: %v", got, want)
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"log"
-	"strconv"
-	"strings"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/alien45/halo-info-bot/client"
@@ -20,24 +20,62 @@ type Bar struct {
 	HighPrice    float64 `json:"h"`
 	LowPrice     float64 `json:"l"`
 	Volume       float64 `json:"v"`
+	// Volume in the currency of the price (sum of amount * price). Eg: ETH for HALO/ETH
+	QuoteVolume float64 `json:"qv"`
+	// Number of trades
+	Trades int `json:"n"`
+	// Volume of trades inferred as buys and sells. See inferTradeTypes.
+	BuyVolume  float64 `json:"bv"`
+	SellVolume float64 `json:"sv"`
+	// Volume weighted average price
+	VWAP float64 `json:"vwap"`
 }
 
-// SetPrices ...
-func (bar *Bar) SetPrices(price float64) {
-	if bar.OpeningPrice == 0 {
-		bar.OpeningPrice = price
+// newBar instantiates a bar starting with the price of it's first trade
+func newBar(start, end time.Time, price float64) Bar {
+	return Bar{
+		Time:         start,
+		TimeEnd:      end,
+		UnixTime:     start.Unix(),
+		OpeningPrice: price,
+		HighPrice:    price,
+		LowPrice:     price,
+		ClosingPrice: price,
 	}
-	if bar.HighPrice < price {
+}
+
+// SetPrices updates high, low and closing prices with the price of a trade.
+// Opening price is set by newBar.
+func (bar *Bar) SetPrices(price float64) {
+	if price > bar.HighPrice {
 		bar.HighPrice = price
 	}
-	if bar.LowPrice == 0 {
-		bar.LowPrice = price
-	} else if bar.LowPrice > price {
+	if price < bar.LowPrice {
 		bar.LowPrice = price
 	}
 	bar.ClosingPrice = price
 }
 
+// AddTrade updates the prices and volumes of the bar with a trade of the given type (buy or sell)
+func (bar *Bar) AddTrade(t client.Trade, tradeType string) {
+	bar.SetPrices(t.Price)
+	bar.Volume += t.Amount
+	bar.QuoteVolume += t.Amount * t.Price
+	bar.Trades++
+	if tradeType == tradeTypeSell {
+		bar.SellVolume += t.Amount
+	} else {
+		bar.BuyVolume += t.Amount
+	}
+	bar.setVWAP()
+}
+
+func (bar *Bar) setVWAP() {
+	if bar.Volume > 0 {
+		bar.VWAP = bar.QuoteVolume / bar.Volume
+	}
+}
+
 func setupResolutions() {
 	resolutions = conf.ChartConfig.Resolutions
 	if len(resolutions) == 0 {
@@ -45,97 +83,262 @@ func setupResolutions() {
 		resolutions = []string{"30", "60", "360", "1D"}
 		conf.ChartConfig.Resolutions = resolutions
 	}
-	for i := 0; i < len(resolutions); i++ {
-		multiplier := 1
-		minStr := resolutions[i]
-		if arr := strings.Split(resolutions[i], "D"); len(arr) > 1 {
-			// Daily resolutions
-			multiplier = 1440
-			minStr = arr[0]
-		} else if arr := strings.Split(resolutions[i], "W"); len(arr) > 1 {
-			// Weekly resolutions
-			multiplier = 10080
-			minStr = arr[0]
-		} else if arr := strings.Split(resolutions[i], "M"); len(arr) > 1 {
-			// Monthly resolutions
-			multiplier = 43200
-			minStr = arr[0]
+	valid := []string{}
+	resolutionSpecs = nil
+	for _, res := range resolutions {
+		r, err := parseResolution(res)
+		if err != nil {
+			log.Println("Ignoring resolution:", err)
+			continue
 		}
-		minutesInt, err := strconv.Atoi(minStr)
-		if err == nil {
-			resolutionMins = append(resolutionMins, minutesInt*multiplier)
+		valid = append(valid, res)
+		resolutionSpecs = append(resolutionSpecs, r)
+	}
+	resolutions = valid
+	conf.ChartConfig.Resolutions = resolutions
+	log.Println("Supported resolutions: ", resolutions)
+}
+
+// isSupportedResolution checks if resolution is one of the configured resolutions
+func isSupportedResolution(resolution string) bool {
+	for _, res := range resolutions {
+		if res == resolution {
+			return true
 		}
 	}
-	log.Println("Supported resolutions: ", resolutions, "=> minutes: ", resolutionMins)
+	return false
 }
 
-func generateNSaveBars(ticker, parentDir string, trades []client.Trade) {
-	log.Println("Generating bars")
-	if cachedBars == nil {
-		cachedBars = map[string]map[string][]Bar{}
+// Version 1: calendar based daily, weekly and monthly bars
+// Version 2: each trade assigned to the bar containing it's time. The last (open) bar is included.
+// Version 3: quote volume, number of trades, buy and sell volume and VWAP
+const barsBucketing = 3
+
+// barsMeta describes the configuration used to generate the persisted bars.
+// Bars are fully regenerated from all trades whenever it changes.
+type barsMeta struct {
+	// Corporate actions applied to the trades
+	CorporateActions   string    `json:"corporateactions"`
+	IgnoreTradesBefore time.Time `json:"ignoretradesbefore"`
+	// Bar generation version. Increment whenever bucketing or bar fields change.
+	Bucketing int `json:"bucketing"`
+	// Timezone of the symbol used to align daily and larger bars
+	TimeZone string `json:"timezone"`
+	// Gap policy of the symbol, if the stored bars are filled
+	Gaps string `json:"gaps"`
+}
+
+func currentBarsMeta(ticker string) barsMeta {
+	symbol, _ := symbols.Get(ticker)
+	timezone := ""
+	if loc := symbol.Location(); loc != time.UTC {
+		timezone = loc.String()
 	}
-	if cachedBars[ticker] == nil {
-		cachedBars[ticker] = map[string][]Bar{}
+	return barsMeta{
+		CorporateActions:   corporateActionsKey(symbol),
+		IgnoreTradesBefore: conf.IgnoreTradesBefore.UTC(),
+		Bucketing:          barsBucketing,
+		TimeZone:           timezone,
+		Gaps:               symbol.gapsKey(),
 	}
-	// Check if there's any pre-split conversion required
-	if strings.ToUpper(conf.SplitTicker) == strings.ToUpper(ticker) && conf.SplitAmount > 0 {
-		for i, t := range trades {
-			if t.Time.Before(conf.PreSplitTime) {
-				// Convert trade amount and price before split to match post-split ratio
-				trades[i].Amount *= conf.SplitAmount
-				trades[i].Price /= conf.SplitAmount
-			}
+}
+
+// requiresRebuild checks if the stored bars were generated using a different configuration
+func requiresRebuild(ticker string) bool {
+	meta, err := store.BarsMeta(ticker)
+	return err != nil || meta != currentBarsMeta(ticker)
+}
+
+// generateNSaveBars updates the bars of all resolutions using the stored trades.
+// Only the trades since the last bar of each resolution are processed and the last bar is replaced.
+// All bars are re-generated if there are no existing bars or if the corporate actions or ignore settings changed.
+func generateNSaveBars(ticker string) {
+	log.Println("Generating bars")
+	rebuild := requiresRebuild(ticker)
+	// Load existing bars and find the earliest trade required to update all of the resolutions
+	existingBars := map[string][]Bar{}
+	since := time.Time{}
+	for _, resName := range resolutions {
+		if rebuild {
+			break
+		}
+		bars, err := getResolution(ticker, resName)
+		if err != nil || len(bars) == 0 {
+			// no existing bars for this resolution. All trades are required.
+			since = time.Time{}
+			rebuild = true
+			break
+		}
+		existingBars[resName] = bars
+		if lastBarTime := bars[len(bars)-1].Time; since.IsZero() || lastBarTime.Before(since) {
+			since = lastBarTime
 		}
 	}
-	// Generate resolution bars
+	if rebuild {
+		existingBars = map[string][]Bar{}
+	}
+	trades, err := tradesWithContext(ticker, since)
+	if err != nil {
+		log.Println("Failed to read trades", ticker, err)
+		return
+	}
+	log.Printf("Generating bars from %d trades. Full rebuild: %v", len(trades), rebuild)
+	// Convert trades before splits, redenominations etc. to the current units
+	symbol, _ := symbols.Get(ticker)
+	applyCorporateActions(symbol, trades)
+	types := inferTradeTypes(trades)
+	// Generate resolution bars. Daily and larger bars follow the symbol's timezone.
+	loc := symbol.Location()
+	prevSynthetic := syntheticBarsSnapshot(ticker)
 	for i, resName := range resolutions {
-		res := resolutionMins[i]
-		log.Println("Generating resolution: ", resName, res)
-		bars, err := generateNSaveResolution(trades, res, resName, parentDir)
+		res := resolutionSpecs[i].In(loc)
+		log.Println("Generating resolution: ", resName)
+		bars, err := generateNSaveResolution(ticker, existingBars[resName], trades, types, res, resName, symbol.FillsGaps(resName))
 		if err != nil {
-			log.Printf("Failed to generate bar for %s resolution %s\n", ticker, resName)
+			log.Printf("Failed to generate bar for %s resolution %s: %v\n", ticker, resName, err)
 			continue
 		}
 		// update cache
-		cachedBars[ticker][fmt.Sprint(res)] = bars
+		prevBars := cachedBars.Set(ticker, resName, bars)
+		publishBarUpdates(ticker, resName, prevBars, bars)
+	}
+	publishSyntheticUpdates(ticker, prevSynthetic)
+	if rebuild {
+		if err = store.SaveBarsMeta(ticker, currentBarsMeta(ticker)); err != nil {
+			log.Println("Failed to save bars meta", ticker, err)
+		}
 	}
 }
 
-func generateNSaveResolution(trades []client.Trade, res int, resName, parentDir string) (bars []Bar, err error) {
-	// Generate X minute resolution bars
-	bars, err = generateResolution(trades, res)
+// tradesWithContext returns the trades since the given time in decending order.
+// Trades of the last bar (of the smallest resolution) before since are included,
+// to infer the type of the first trades since then the same way as when all trades are read.
+func tradesWithContext(ticker string, since time.Time) ([]client.Trade, error) {
+	since, err := contextStart(ticker, since)
 	if err != nil {
 		return nil, err
 	}
-	return bars, client.SaveJSONFile(fmt.Sprintf("%s/%s.json", parentDir, resName), bars)
+	return store.TradesSince(ticker, since)
 }
 
-// Expects trades to be in decending order
-func generateResolution(trades []client.Trade, resolutionMins int) (bars []Bar, err error) {
-	bar := Bar{}
-	// Ignore the first few TEST trades by Halo team
+// contextStart returns the start of the last bar (of the smallest resolution) with trades before since.
+// Returns since if there is none.
+func contextStart(ticker string, since time.Time) (time.Time, error) {
+	for to := since.Unix() - 1; !since.IsZero(); {
+		bars, err := store.LastBars(ticker, smallestResolution(), to, 100)
+		if err != nil {
+			return since, err
+		}
+		// skip the bars filled in gaps
+		i := len(bars) - 1
+		for i >= 0 && bars[i].Trades == 0 {
+			i--
+		}
+		if i >= 0 {
+			return bars[i].Time, nil
+		}
+		if len(bars) < 100 {
+			// no trades before since
+			break
+		}
+		to = bars[0].UnixTime - 1
+	}
+	return since, nil
+}
+
+// generateNSaveResolution replaces the last of the existing bars with bars generated from trades.
+// Trades before the last existing bar are ignored.
+// types contains the type (buy or sell) of each trade.
+// If fill is true, the intervals without trades are filled with flat bars until now.
+func generateNSaveResolution(ticker string, existing []Bar, trades []client.Trade, types []string, res Resolution, resName string, fill bool) (bars []Bar, err error) {
+	from := int64(math.MinInt64)
+	if n := len(existing); n > 0 {
+		since := existing[n-1].Time
+		from = existing[n-1].UnixTime
+		// the last bar may not have been complete. Keep all but the last bar
+		existing = existing[:n-1]
+		n = sort.Search(len(trades), func(i int) bool { return trades[i].Time.Before(since) })
+		trades = trades[:n]
+		types = types[:n]
+	}
+	// Generate resolution bars
+	newBars, err := generateResolution(trades, types, res)
+	if err != nil {
+		return nil, err
+	}
+	if fill {
+		var prev *Bar
+		if n := len(existing); n > 0 {
+			prev = &existing[n-1]
+		}
+		newBars = fillGaps(prev, newBars, res, clock.Now())
+	}
+	if err = store.SaveBars(ticker, resName, from, newBars); err != nil {
+		return nil, err
+	}
+	return append(append([]Bar{}, existing...), newBars...), nil
+}
+
+// fillGaps adds flat bars with the previous closing price and zero volume for the intervals without trades
+// between prev (optional) and bars, and after the last bar until the bar containing `until`.
+// Expects bars to be in ascending order.
+func fillGaps(prev *Bar, bars []Bar, res Resolution, until time.Time) (filled []Bar) {
+	var next time.Time
+	closingPrice := 0.0
+	if prev != nil {
+		next = res.End(prev.Time)
+		closingPrice = prev.ClosingPrice
+	}
+	addFlatBars := func(before time.Time) {
+		for ; !next.IsZero() && next.Before(before); next = res.End(next) {
+			filled = append(filled, newBar(next, res.End(next), closingPrice))
+		}
+	}
+	for _, bar := range bars {
+		addFlatBars(bar.Time)
+		filled = append(filled, bar)
+		next = res.End(bar.Time)
+		closingPrice = bar.ClosingPrice
+	}
+	addFlatBars(res.End(res.Start(until)))
+	return
+}
+
+// readBarsFile loads bars from a JSON file
+func readBarsFile(filename string) (bars []Bar, err error) {
+	jsonStr, err := client.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(jsonStr), &bars)
+	return
+}
+
+// generateResolution groups trades into bars of the resolution.
+// Each trade belongs to the bar starting at res.Start(trade time), ie: a trade exactly
+// at the end of a bar belongs to the next bar. The last bar may still be open.
+// Bars without trades are not generated.
+// types contains the type (buy or sell) of each trade.
+// Expects trades to be in decending order. Returns bars in ascending order.
+func generateResolution(trades []client.Trade, types []string, res Resolution) (bars []Bar, err error) {
+	now := clock.Now()
 	for i := len(trades) - 1; i >= 0; i-- {
 		t := trades[i]
+		// Ignore the first few TEST trades by Halo team
 		if t.Time.Before(conf.IgnoreTradesBefore) {
 			continue
 		}
-
-		if bar.Time.IsZero() {
-			// Find closest starting point
-			bar.Time = t.Time.Truncate(time.Minute * time.Duration(resolutionMins))
-			bar.TimeEnd = bar.Time.Add(time.Minute * time.Duration(resolutionMins))
-			bar.UnixTime = bar.Time.Unix()
-			bar.SetPrices(t.Price)
-			continue
+		if t.Time.After(now) {
+			// ignore trades that are yet to happen according to the server clock. Eg: when replaying
+			break
 		}
-		// bar time is set. check if current trade is within the bar time range
-		if t.Time.After(bar.TimeEnd) {
-			bars = append(bars, bar)
-			// start of the next bar
-			bar = Bar{}
+		start := res.Start(t.Time)
+		n := len(bars)
+		if n == 0 || !bars[n-1].Time.Equal(start) {
+			bars = append(bars, newBar(start, res.End(start), t.Price))
+			n++
 		}
-		bar.SetPrices(t.Price)
-		bar.Volume += t.Amount
+		bars[n-1].AddTrade(t, types[i])
 	}
 	return
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// TestGenerateResolution compares the bars generated from the trades with the hand-computed
+// bars of the golden files testdata/golden/bars_<name>.json
+func TestGenerateResolution(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name       string
+		resolution string
+		location   *time.Location
+		now        time.Time
+		trades     []client.Trade // ascending order
+	}{
+		{
+			// first trade volume, bar time of the first trade of a bar, trade at the end of a bar,
+			// open bar and trades after now
+			"minutes",
+			"15",
+			nil,
+			at("2019-01-01T00:40:00Z"),
+			[]client.Trade{
+				{Time: at("2019-01-01T00:00:00Z"), Price: 2, Amount: 10},
+				{Time: at("2019-01-01T00:07:30Z"), Price: 4, Amount: 10},
+				{Time: at("2019-01-01T00:15:00Z"), Price: 3, Amount: 2},
+				{Time: at("2019-01-01T00:16:00Z"), Price: 0, Amount: 4},
+				{Time: at("2019-01-01T00:29:59Z"), Price: 1, Amount: 1},
+				{Time: at("2019-01-01T00:35:00Z"), Price: 6, Amount: 3},
+				{Time: at("2019-01-01T00:50:00Z"), Price: 9, Amount: 1},
+			},
+		},
+		{
+			"zero_price",
+			"60",
+			nil,
+			testNow,
+			[]client.Trade{
+				{Time: at("2019-01-01T01:00:00Z"), Price: 0, Amount: 5},
+				{Time: at("2019-01-01T01:10:00Z"), Price: 2, Amount: 5},
+				{Time: at("2019-01-01T01:20:00Z"), Price: 0, Amount: 10},
+				{Time: at("2019-01-01T02:00:00Z"), Price: 0, Amount: 1},
+			},
+		},
+		{
+			// ISO weeks start on Monday. 2018-12-31 is a Monday.
+			"week",
+			"1W",
+			nil,
+			testNow.AddDate(1, 0, 0),
+			[]client.Trade{
+				{Time: at("2018-12-30T23:59:59Z"), Price: 1, Amount: 1},
+				{Time: at("2018-12-31T00:00:00Z"), Price: 2, Amount: 1},
+				{Time: at("2019-01-06T23:59:59Z"), Price: 3, Amount: 1},
+				{Time: at("2019-01-07T00:00:00Z"), Price: 4, Amount: 1},
+			},
+		},
+		{
+			"month",
+			"1M",
+			nil,
+			testNow.AddDate(1, 0, 0),
+			[]client.Trade{
+				{Time: at("2019-01-31T23:59:59Z"), Price: 1, Amount: 1},
+				{Time: at("2019-02-01T00:00:00Z"), Price: 2, Amount: 1},
+				{Time: at("2019-02-28T12:00:00Z"), Price: 1, Amount: 1},
+				{Time: at("2019-03-01T00:00:00Z"), Price: 3, Amount: 1},
+			},
+		},
+		{
+			"year",
+			"12M",
+			nil,
+			testNow.AddDate(2, 0, 0),
+			[]client.Trade{
+				{Time: at("2018-12-31T23:59:59Z"), Price: 1, Amount: 1},
+				{Time: at("2019-01-01T00:00:00Z"), Price: 2, Amount: 1},
+				{Time: at("2019-07-01T00:00:00Z"), Price: 4, Amount: 1},
+				{Time: at("2020-01-01T00:00:00Z"), Price: 3, Amount: 1},
+			},
+		},
+		{
+			// DST starts on 2019-03-10 at 2am in New York. The day is 23 hours long.
+			"day_dst",
+			"1D",
+			newYork,
+			testNow.AddDate(1, 0, 0),
+			[]client.Trade{
+				{Time: at("2019-03-10T04:59:59Z"), Price: 1, Amount: 1},
+				{Time: at("2019-03-10T05:00:00Z"), Price: 2, Amount: 1},
+				{Time: at("2019-03-11T03:59:59Z"), Price: 3, Amount: 1},
+				{Time: at("2019-03-11T04:00:00Z"), Price: 4, Amount: 1},
+			},
+		},
+	}
+	conf = Config{}
+	t.Cleanup(func() { clock = systemClock{} })
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock = NewManualClock(test.now)
+			res, err := parseResolution(test.resolution)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// decending order, as stored
+			trades := []client.Trade{}
+			for i := len(test.trades) - 1; i >= 0; i-- {
+				trades = append(trades, test.trades[i])
+			}
+			bars, err := generateResolution(trades, inferTradeTypes(trades), res.In(test.location))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, "testdata/golden/bars_"+test.name+".json", bars)
+		})
+	}
+}
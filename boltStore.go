@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt bucket and key names. Each ticker has it's own top-level bucket:
+//
+//	<ticker>/trades     : <unix nano time><sequence> => trade JSON
+//	<ticker>/bars/<res> : <unix time> => bar JSON
+//	<ticker>/meta       : bars meta JSON
+//
+// Annotations of all symbols are stored in a separate top-level bucket:
+//
+//	_annotations : <id> => annotation JSON
+var boltTradesBucket = []byte("trades")
+var boltBarsBucket = []byte("bars")
+var boltMetaKey = []byte("meta")
+var boltAnnotationsBucket = []byte("_annotations")
+
+// boltStore stores trades and bars of all symbols in a single embedded BoltDB file.
+// Trades and bars are keyed by big-endian time to allow range queries.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(filename string) (s *boltStore, err error) {
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return
+	}
+	db, err := bolt.Open(filename, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return
+	}
+	return &boltStore{db: db}, nil
+}
+
+func boltTimeKey(t int64) []byte {
+	key := make([]byte, 8)
+	// offset to keep negative values sorted before positive values
+	binary.BigEndian.PutUint64(key, uint64(t)^(1<<63))
+	return key
+}
+
+func boltKeyTime(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key[:8]) ^ (1 << 63))
+}
+
+func boltTickerKey(ticker string) []byte {
+	return []byte(strings.ToLower(ticker))
+}
+
+// boltBucket returns the nested bucket by path. Returns nil if any bucket does not exist.
+func boltBucket(tx *bolt.Tx, path ...[]byte) (b *bolt.Bucket) {
+	for i, name := range path {
+		if i == 0 {
+			b = tx.Bucket(name)
+		} else {
+			b = b.Bucket(name)
+		}
+		if b == nil {
+			return nil
+		}
+	}
+	return
+}
+
+// createBoltBucket returns the nested bucket by path. Missing buckets are created.
+func createBoltBucket(tx *bolt.Tx, path ...[]byte) (b *bolt.Bucket, err error) {
+	for i, name := range path {
+		if i == 0 {
+			b, err = tx.CreateBucketIfNotExists(name)
+		} else {
+			b, err = b.CreateBucketIfNotExists(name)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *boltStore) AppendTrades(ticker string, trades []client.Trade) error {
+	if len(trades) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBoltBucket(tx, boltTickerKey(ticker), boltTradesBucket)
+		if err != nil {
+			return err
+		}
+		for i := len(trades) - 1; i >= 0; i-- {
+			value, err := json.Marshal(trades[i])
+			if err != nil {
+				return err
+			}
+			// sequence makes sure trades with identical time are kept
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 16)
+			copy(key, boltTimeKey(trades[i].Time.UnixNano()))
+			binary.BigEndian.PutUint64(key[8:], seq)
+			if err = b.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) LastTradeTime(ticker string) (t time.Time, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := boltBucket(tx, boltTickerKey(ticker), boltTradesBucket)
+		if b == nil {
+			return nil
+		}
+		key, _ := b.Cursor().Last()
+		if key != nil {
+			t = time.Unix(0, boltKeyTime(key)).UTC()
+		}
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) TradesSince(ticker string, since time.Time) (trades []client.Trade, err error) {
+	return s.TradesBetween(ticker, since, maxTradeTime)
+}
+
+func (s *boltStore) TradesBetween(ticker string, since, until time.Time) (trades []client.Trade, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := boltBucket(tx, boltTickerKey(ticker), boltTradesBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		key, value := c.First()
+		if !since.IsZero() {
+			key, value = c.Seek(boltTimeKey(since.UnixNano()))
+		}
+		for ; key != nil && boltKeyTime(key) <= until.UnixNano(); key, value = c.Next() {
+			trade := client.Trade{}
+			if err := json.Unmarshal(value, &trade); err != nil {
+				return err
+			}
+			trades = append(trades, trade)
+		}
+		return nil
+	})
+	// reverse to decending order
+	for i, j := 0, len(trades)-1; i < j; i, j = i+1, j-1 {
+		trades[i], trades[j] = trades[j], trades[i]
+	}
+	return
+}
+
+func (s *boltStore) QueryBars(ticker, resolution string, from, to int64) (bars []Bar, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := boltBucket(tx, boltTickerKey(ticker), boltBarsBucket, []byte(resolution))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for key, value := c.Seek(boltTimeKey(from)); key != nil && boltKeyTime(key) <= to; key, value = c.Next() {
+			bar := Bar{}
+			if err := json.Unmarshal(value, &bar); err != nil {
+				return err
+			}
+			bars = append(bars, bar)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) LastBars(ticker, resolution string, to int64, count int) (bars []Bar, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := boltBucket(tx, boltTickerKey(ticker), boltBarsBucket, []byte(resolution))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		key, value := c.Last()
+		if to < math.MaxInt64 {
+			// first bar after `to` or nil if there is none
+			if key, value = c.Seek(boltTimeKey(to + 1)); key == nil {
+				key, value = c.Last()
+			}
+		}
+		for ; key != nil && len(bars) < count; key, value = c.Prev() {
+			if boltKeyTime(key) > to {
+				continue
+			}
+			bar := Bar{}
+			if err := json.Unmarshal(value, &bar); err != nil {
+				return err
+			}
+			bars = append(bars, bar)
+		}
+		return nil
+	})
+	// reverse to ascending order
+	for i, j := 0, len(bars)-1; i < j; i, j = i+1, j-1 {
+		bars[i], bars[j] = bars[j], bars[i]
+	}
+	return
+}
+
+func (s *boltStore) SaveBars(ticker, resolution string, from int64, bars []Bar) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBoltBucket(tx, boltTickerKey(ticker), boltBarsBucket, []byte(resolution))
+		if err != nil {
+			return err
+		}
+		// remove replaced bars
+		c := b.Cursor()
+		for key, _ := c.Seek(boltTimeKey(from)); key != nil; key, _ = c.Seek(boltTimeKey(from)) {
+			if err = c.Delete(); err != nil {
+				return err
+			}
+		}
+		for _, bar := range bars {
+			value, err := json.Marshal(bar)
+			if err != nil {
+				return err
+			}
+			if err = b.Put(boltTimeKey(bar.UnixTime), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) BarsMeta(ticker string) (meta barsMeta, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := boltBucket(tx, boltTickerKey(ticker))
+		if b == nil {
+			return os.ErrNotExist
+		}
+		value := b.Get(boltMetaKey)
+		if value == nil {
+			return os.ErrNotExist
+		}
+		return json.Unmarshal(value, &meta)
+	})
+	return
+}
+
+func (s *boltStore) SaveBarsMeta(ticker string, meta barsMeta) error {
+	value, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBoltBucket(tx, boltTickerKey(ticker))
+		if err != nil {
+			return err
+		}
+		return b.Put(boltMetaKey, value)
+	})
+}
+
+func (s *boltStore) Annotations() (annotations []Annotation, err error) {
+	annotations = []Annotation{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltAnnotationsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, value []byte) error {
+			a := Annotation{}
+			if err := json.Unmarshal(value, &a); err != nil {
+				return err
+			}
+			annotations = append(annotations, a)
+			return nil
+		})
+	})
+	sort.SliceStable(annotations, func(i, j int) bool { return annotations[i].Time.Before(annotations[j].Time) })
+	return
+}
+
+func (s *boltStore) SaveAnnotation(a Annotation) error {
+	value, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltAnnotationsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(a.ID), value)
+	})
+}
+
+func (s *boltStore) DeleteAnnotation(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltAnnotationsBucket)
+		if b == nil || b.Get([]byte(id)) == nil {
+			return os.ErrNotExist
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Clock provides the current server time.
+// Allows tests and replay tools to inject a fake time source.
+type Clock interface {
+	Now() time.Time
+	Tick(d time.Duration) <-chan time.Time
+}
+
+// server clock. Use a ManualClock to replay or test.
+var clock Clock = systemClock{}
+
+// systemClock uses the system time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now().UTC() }
+
+func (systemClock) Tick(d time.Duration) <-chan time.Time { return time.Tick(d) }
+
+// ManualClock is a Clock that only moves when Set or Advance is invoked.
+// Tick channels fire whenever the clock is moved past their next tick time.
+type ManualClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+type manualTicker struct {
+	interval time.Duration
+	next     time.Time
+	ch       chan time.Time
+}
+
+// NewManualClock instantiates a ManualClock starting at the given time
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now.UTC()}
+}
+
+// Now returns the current time of the clock
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Tick returns a channel which receives the clock time on every interval
+func (c *ManualClock) Tick(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &manualTicker{interval: d, next: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t.ch
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to the given time and fires all due tickers.
+// Similar to time.Ticker, ticks are dropped if the receiver is not ready.
+func (c *ManualClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now.UTC()
+	for _, t := range c.tickers {
+		if t.next.After(c.now) {
+			continue
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.interval)
+		}
+		select {
+		case t.ch <- c.now:
+		default:
+		}
+	}
+}
+
+// timeHandler responds with the current server time in Unix Epoch seconds as plain text.
+// https://github.com/tradingview/charting_library/wiki/UDF#server-time
+func timeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(ok200)
+	fmt.Fprint(w, clock.Now().Unix())
+}
+
+// ReplayConfig describes how to replay trades using a ManualClock
+type ReplayConfig struct {
+	// Server time at startup. Replay is disabled if empty.
+	Start time.Time `json:"start"`
+	// Real time in seconds per sync interval. Default: 1
+	IntervalSecs int `json:"intervalsecs"`
+}
+
+// setupReplay replaces the server clock with a ManualClock starting at c.Start
+// which moves forward by one sync interval every c.IntervalSecs.
+// Use with a "fixture" trade source to replay trade history.
+func setupReplay(c ReplayConfig) {
+	if c.Start.IsZero() {
+		return
+	}
+	if c.IntervalSecs <= 0 {
+		c.IntervalSecs = 1
+	}
+	manualClock := NewManualClock(c.Start)
+	clock = manualClock
+	step := time.Minute * time.Duration(conf.SyncIntervalMins)
+	log.Printf("Replaying from %s. %s every %d second(s)", c.Start, step, c.IntervalSecs)
+	go func() {
+		for range time.Tick(time.Second * time.Duration(c.IntervalSecs)) {
+			manualClock.Advance(step)
+		}
+	}()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// Corporate action types
+const (
+	// Each token becomes Ratio tokens. Eg: Ratio 800 => 1 HALO becomes 800 HALO
+	actionSplit = "split"
+	// Ratio tokens become a single token. Eg: Ratio 10 => 10 tokens become 1 token
+	actionReverseSplit = "reversesplit"
+	// Each token is re-denominated to Ratio new units. Eg: Ratio 0.001 => 1000 old units become 1 new unit
+	actionRedenomination = "redenomination"
+	// Token moved to a new contract address. Each old token is swapped for Ratio (default 1) new tokens.
+	actionMigration = "migration"
+)
+
+// CorporateAction describes a change of a token's units or contract.
+// Trades before the action are converted to the units after the action, for all pairs involving the token.
+type CorporateAction struct {
+	// Ticker of the token. Eg: "HALO"
+	Token string `json:"token"`
+	// split, reversesplit, redenomination or migration
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// See action types
+	Ratio float64 `json:"ratio"`
+	// Contract addresses before and after a migration
+	OldAddress string `json:"oldaddress,omitempty"`
+	NewAddress string `json:"newaddress,omitempty"`
+	// Displayed on chart marks
+	Description string `json:"description,omitempty"`
+}
+
+// unitsFactor returns the number of new token units per unit before the action
+func (a CorporateAction) unitsFactor() float64 {
+	switch a.Type {
+	case actionReverseSplit:
+		return 1 / a.Ratio
+	case actionMigration:
+		if a.Ratio == 0 {
+			return 1
+		}
+	}
+	return a.Ratio
+}
+
+// Text describes the action. Eg: "HALO 1:800 split"
+func (a CorporateAction) Text() string {
+	if a.Description != "" {
+		return a.Description
+	}
+	switch a.Type {
+	case actionSplit:
+		return fmt.Sprintf("%s 1:%g split", a.Token, a.Ratio)
+	case actionReverseSplit:
+		return fmt.Sprintf("%s %g:1 reverse split", a.Token, a.Ratio)
+	case actionRedenomination:
+		return fmt.Sprintf("%s redenominated. 1 old unit = %g new units", a.Token, a.Ratio)
+	}
+	return fmt.Sprintf("%s migrated to new contract %s", a.Token, a.NewAddress)
+}
+
+// setupCorporateActions validates the corporate actions and sorts them by time.
+// The deprecated "splitticker", "presplittime" and "splitamount" settings are converted to a split action.
+func setupCorporateActions() error {
+	if conf.SplitTicker != "" && conf.SplitAmount > 0 {
+		conf.CorporateActions = append(conf.CorporateActions, CorporateAction{
+			Token: conf.SplitTicker,
+			Type:  actionSplit,
+			Time:  conf.PreSplitTime,
+			Ratio: conf.SplitAmount,
+		})
+	}
+	for i := range conf.CorporateActions {
+		a := &conf.CorporateActions[i]
+		a.Token = strings.ToUpper(strings.TrimSpace(a.Token))
+		a.Type = strings.ToLower(a.Type)
+		a.Time = a.Time.UTC()
+		if a.Token == "" {
+			return fmt.Errorf("corporateactions[%d]: token is required", i)
+		}
+		if a.Time.IsZero() {
+			return fmt.Errorf("corporateactions[%d]: time is required", i)
+		}
+		switch a.Type {
+		case actionSplit, actionReverseSplit, actionRedenomination:
+			if a.Ratio <= 0 {
+				return fmt.Errorf("corporateactions[%d]: ratio must be positive", i)
+			}
+		case actionMigration:
+			if a.Ratio < 0 {
+				return fmt.Errorf("corporateactions[%d]: ratio must not be negative", i)
+			}
+		default:
+			return fmt.Errorf("corporateactions[%d]: invalid type: %s", i, a.Type)
+		}
+	}
+	sort.SliceStable(conf.CorporateActions, func(i, j int) bool {
+		return conf.CorporateActions[i].Time.Before(conf.CorporateActions[j].Time)
+	})
+	return nil
+}
+
+// corporateActionsOf returns the actions of all tokens of the symbol in chronological order
+func corporateActionsOf(symbol Symbol) (actions []CorporateAction) {
+	m := symbol.market()
+	for _, a := range conf.CorporateActions {
+		if strings.EqualFold(a.Token, m.QuoteTicker) || strings.EqualFold(a.Token, m.BaseTicker) {
+			actions = append(actions, a)
+		}
+	}
+	return
+}
+
+// corporateActionsKey identifies the actions applied to the symbol's bars.
+// Bars are regenerated whenever it changes.
+func corporateActionsKey(symbol Symbol) string {
+	actions := corporateActionsOf(symbol)
+	if len(actions) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(actions)
+	return string(b)
+}
+
+// applyCorporateActions converts the price and amount of the trades made before
+// any of the actions of the symbol's tokens to the units after the action.
+// Quote token actions change both amount and price, base token actions only change the price.
+// Trades are modified in place.
+func applyCorporateActions(symbol Symbol, trades []client.Trade) {
+	m := symbol.market()
+	for _, a := range corporateActionsOf(symbol) {
+		factor := a.unitsFactor()
+		isQuote := strings.EqualFold(a.Token, m.QuoteTicker)
+		for i, t := range trades {
+			if !t.Time.Before(a.Time) {
+				continue
+			}
+			if isQuote {
+				trades[i].Amount *= factor
+				trades[i].Price /= factor
+			} else {
+				// price is in base token units
+				trades[i].Price *= factor
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const eventsKeepAliveInterval = 30 * time.Second
+
+// eventsHandler streams bar updates and new trades as Server-Sent Events (text/event-stream).
+// Fallback for clients which are unable to use the WebSocket based "/stream".
+// Bar events carry the bar time as the event ID. When reconnecting with the
+// "Last-Event-ID" header (or "lastEventId" param), all bars since then are sent
+// from the cache before the live events.
+// GET Params:
+// @symbol
+// @resolution
+// @lastEventId (optional)
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, "Streaming not supported", err500)
+		return
+	}
+	params := newRequestParams(r)
+	symbol := params.Symbol("symbol")
+	resolution := params.Resolution("resolution")
+	if params.Valid() && !isSupportedResolution(resolution) {
+		// derived resolutions are not updated by sync
+		params.Invalidate(errmsgInvalidResolution, err400)
+	}
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = params.String("lastEventId", false)
+	}
+
+	subscriber := hub.Register()
+	defer hub.Unregister(subscriber)
+	subscriber.Subscribe(symbol.Ticker, resolution)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(ok200)
+
+	// Resume by sending the bars missed since last event
+	bars, err := getResolution(strings.ToLower(symbol.Ticker), resolution)
+	if err != nil {
+		log.Println("[events] failed to load bars", err)
+	}
+	since, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil && len(bars) > 0 {
+		// Not resuming. Only send the latest bar.
+		since = bars[len(bars)-1].UnixTime
+	}
+	for i := 0; i < len(bars); i++ {
+		if bars[i].UnixTime < since {
+			continue
+		}
+		bar := bars[i]
+		eventType := streamEventBarClose
+		if i == len(bars)-1 {
+			eventType = streamEventBar
+		}
+		err = writeServerSentEvent(w, StreamEvent{
+			Type:       eventType,
+			Symbol:     symbol.Ticker,
+			Resolution: resolution,
+			Bar:        &bar,
+		})
+		if err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscriber.Events:
+			if !ok {
+				return
+			}
+			if err := writeServerSentEvent(w, event); err != nil {
+				log.Println("[events] write failed", err)
+				return
+			}
+		case <-keepAlive.C:
+			// comment line to prevent proxies from closing idle connections
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeServerSentEvent writes event in the text/event-stream format.
+// Bar events use the bar time as event ID to allow resuming.
+func writeServerSentEvent(w http.ResponseWriter, event StreamEvent) (err error) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if event.Bar != nil {
+		if _, err = fmt.Fprintf(w, "id: %d\n", event.Bar.UnixTime); err != nil {
+			return
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, b)
+	return
+}
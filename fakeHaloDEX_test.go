@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// newFakeHaloDEX starts an HTTP server mimicking the HaloDEX token and trade endpoints used by client.DEX.
+// Tokens and trades are served from fixture files:
+//
+//	<dir>/tokens.json                : array of tokens
+//	<dir>/trades/<QUOTE>-<BASE>.json : array of trades of the pair in any order
+//
+// Endpoints:
+//
+//	GET /tokens                                     all tokens
+//	GET /trades?quote=ADDR&base=ADDR&since=RFC3339  trades of the pair on or after since in decending order
+func newFakeHaloDEX(t *testing.T, dir string) *httptest.Server {
+	txt, err := client.ReadFile(dir + "/tokens.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := []client.Token{}
+	if err = json.Unmarshal([]byte(txt), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	tickers := map[string]string{} // address : ticker
+	for _, token := range tokens {
+		tickers[strings.ToLower(token.HaloChainAddress)] = token.Ticker
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tokens", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokens)
+	})
+	mux.HandleFunc("/trades", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		quote, qFound := tickers[strings.ToLower(query.Get("quote"))]
+		base, bFound := tickers[strings.ToLower(query.Get("base"))]
+		if !qFound || !bFound {
+			http.Error(w, "unknown token", http.StatusNotFound)
+			return
+		}
+		since := time.Time{}
+		if s := query.Get("since"); s != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+		all := []client.Trade{}
+		if txt, err := client.ReadFile(dir + "/trades/" + quote + "-" + base + ".json"); err == nil {
+			if err = json.Unmarshal([]byte(txt), &all); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		trades := []client.Trade{}
+		for _, trade := range all {
+			if !trade.Time.Before(since) {
+				trades = append(trades, trade)
+			}
+		}
+		sort.SliceStable(trades, func(i, j int) bool { return trades[i].Time.After(trades[j].Time) })
+		json.NewEncoder(w).Encode(trades)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
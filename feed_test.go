@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// Server time of the test feed
+var testNow = time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// Handlers are registered to the default mux only once per test run
+var registerHandlersOnce sync.Once
+
+// startTestFeed starts the feed with a fake HaloDEX serving the trades of testdata/halodex,
+// synchronizes the trades of all symbols and returns the feed server.
+// The store is created in a temporary directory and the clock is fixed at testNow.
+func startTestFeed(t *testing.T) *httptest.Server {
+	dex := newFakeHaloDEX(t, "testdata/halodex")
+	configJSON := fmt.Sprintf(`{
+		"sources": [{"name": "HaloDEX", "type": "halodex", "halodex": {"url": %q, "urlgql": %q}}],
+		"store": {"type": "file", "path": %q},
+		"chartconfig": {
+			"supported_resolutions": ["15", "60", "1D"],
+			"supports_group_request": true,
+			"supports_search": true
+		}
+	}`, dex.URL, dex.URL, t.TempDir())
+	conf = Config{}
+	if err := json.Unmarshal([]byte(configJSON), &conf); err != nil {
+		t.Fatal(err)
+	}
+	clock = NewManualClock(testNow)
+	t.Cleanup(func() { clock = systemClock{} })
+	cachedBars = newBarCache()
+	derivedBars = newDerivedCache(derivedCacheSize)
+	whaleMarks = newWhaleCache()
+	setup()
+	registerHandlersOnce.Do(func() { registerHanders(handlers()) })
+	for _, symbol := range symbols.List() {
+		if err := syncTicker(symbol.Ticker, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	feed := httptest.NewServer(http.DefaultServeMux)
+	t.Cleanup(feed.Close)
+	return feed
+}
+
+// get requests the path from the server and returns the status code and body
+func get(t *testing.T, server *httptest.Server, path string) (int, []byte) {
+	res, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, body
+}
+
+// assertJSON compares the JSON values regardless of formatting
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("invalid expected JSON: %v\n%s", err, want)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("unexpected JSON\n got: %s\nwant: %s", got, want)
+	}
+}
+
+// assertGolden compares the JSON encoded value with the golden file.
+// Run the tests with -update to write the golden files.
+func assertGolden(t *testing.T, filename string, value interface{}) {
+	t.Helper()
+	got, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+	if *updateGolden {
+		if err = os.WriteFile(filename, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSON(t, got, string(want))
+}
+
+func TestSymbols(t *testing.T) {
+	feed := startTestFeed(t)
+	for _, name := range []string{"HALO/ETH", "HaloDEX:HALO/ETH", "halo/eth"} {
+		status, body := get(t, feed, "/symbols?symbol="+name)
+		if status != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", name, status, body)
+		}
+		symbol := map[string]interface{}{}
+		if err := json.Unmarshal(body, &symbol); err != nil {
+			t.Fatal(err)
+		}
+		assertGolden(t, "testdata/golden/symbols_HALO-ETH.json", symbol)
+	}
+	status, body := get(t, feed, "/symbols?symbol=XYZ/ETH")
+	if status != http.StatusNotFound {
+		t.Errorf("unknown symbol: unexpected status %d: %s", status, body)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	feed := startTestFeed(t)
+	haloETH := `{"symbol":"HALO/ETH","full_name":"Halo Platform","description":"Halo Platform","exchange":"HaloDEX","ticker":"HALO/ETH","type":"bitcoin"}`
+	haloUSDT := `{"symbol":"HALO/USDT","full_name":"Halo Platform","description":"Halo Platform","exchange":"HaloDEX","ticker":"HALO/USDT","type":"bitcoin"}`
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"query=halo", "[" + haloETH + "," + haloUSDT + "]"},
+		{"query=eth", "[" + haloETH + "]"},
+		{"query=HALO&exchange=haloDEX&type=bitcoin", "[" + haloETH + "," + haloUSDT + "]"},
+		{"query=halo&limit=1", "[" + haloETH + "]"},
+		{"query=halo&exchange=Other", "[]"},
+		{"query=halo&type=stock", "[]"},
+		{"query=btc", "[]"},
+	}
+	for _, test := range tests {
+		status, body := get(t, feed, "/search?"+test.query)
+		if status != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", test.query, status, body)
+		}
+		assertJSON(t, body, test.want)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	feed := startTestFeed(t)
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			"stored minutes",
+			"symbol=HALO/ETH&resolution=15&from=1546300800&to=1546387200",
+			`{"s":"ok","errmsg":"","t":[1546300800,1546301700,1546303500,1546304400,1546313400],
+			"o":[0.0001,0.00012,0.00011,0.00013,0.00009],"h":[0.0001,0.00012,0.00011,0.00013,0.00009],
+			"l":[0.0001,0.00012,0.00011,0.00013,0.00009],"c":[0.0001,0.00012,0.00011,0.00013,0.00009],
+			"v":[100,50,10,20,40],"nextTime":0}`,
+		},
+		{
+			"trade at the end of a bar belongs to the next bar",
+			"symbol=HaloDEX:HALO/ETH&resolution=60&from=1546300800&to=1546387200",
+			`{"s":"ok","errmsg":"","t":[1546300800,1546304400,1546311600],
+			"o":[0.0001,0.00013,0.00009],"h":[0.00012,0.00013,0.00009],"l":[0.0001,0.00013,0.00009],
+			"c":[0.00011,0.00013,0.00009],"v":[160,20,40],"nextTime":0}`,
+		},
+		{
+			"daily",
+			"symbol=HALO/ETH&resolution=1D&from=1546214400&to=1546387200",
+			`{"s":"ok","errmsg":"","t":[1546300800],"o":[0.0001],"h":[0.00013],"l":[0.00009],"c":[0.00009],
+			"v":[220],"nextTime":0}`,
+		},
+		{
+			"derived",
+			"symbol=HALO/ETH&resolution=120&from=1546300800&to=1546387200",
+			`{"s":"ok","errmsg":"","t":[1546300800,1546308000],"o":[0.0001,0.00009],"h":[0.00013,0.00009],
+			"l":[0.0001,0.00009],"c":[0.00013,0.00009],"v":[180,40],"nextTime":0}`,
+		},
+		{
+			"countback",
+			"symbol=HALO/ETH&resolution=60&from=0&to=1546308000&countback=1",
+			`{"s":"ok","errmsg":"","t":[1546304400],"o":[0.00013],"h":[0.00013],"l":[0.00013],"c":[0.00013],
+			"v":[20],"nextTime":0}`,
+		},
+		{
+			"extended",
+			"symbol=HALO/ETH&resolution=1D&from=1546300800&to=1546387200&extended=1",
+			`{"s":"ok","errmsg":"","t":[1546300800],"o":[0.0001],"h":[0.00013],"l":[0.00009],"c":[0.00009],
+			"v":[220],"qv":[0.0233],"n":[5],"bv":[170],"sv":[50],"vwap":[0.00010590909090909092],"nextTime":0}`,
+		},
+		{
+			"no data after the last bar",
+			"symbol=HALO/ETH&resolution=60&from=1546315200&to=1546318800",
+			`{"s":"no_data","errmsg":"","t":null,"o":null,"h":null,"l":null,"c":null,"v":null,"nextTime":1546311600}`,
+		},
+		{
+			"no trades",
+			"symbol=HALO/USDT&resolution=60&from=1546300800&to=1546387200",
+			`{"s":"no_data","errmsg":"","t":null,"o":null,"h":null,"l":null,"c":null,"v":null,"nextTime":0}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := get(t, feed, "/history?"+test.query)
+			if status != http.StatusOK {
+				t.Fatalf("unexpected status %d: %s", status, body)
+			}
+			assertJSON(t, body, test.want)
+		})
+	}
+}
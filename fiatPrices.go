@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Type of symbols of which the bars are imported fiat reference prices
+	symbolTypeReference = "index"
+	// Exchange of reference price symbols. Tickers are prefixed with it. Eg: "Reference:ETH/USD"
+	referenceExchange = "Reference"
+	// Default fiat currency of reference prices
+	defaultFiatCurrency = "USD"
+	// Default resolution of reference price candles
+	defaultFiatResolution = "1D"
+)
+
+// FiatPriceConfig describes the source of the fiat reference prices of a base token.
+// Pairs of the token are also available in the fiat currency as synthetic pairs. Eg: "HALO/USD" from HALO/ETH and ETH/USD.
+type FiatPriceConfig struct {
+	// Base token ticker. Eg: "ETH"
+	Token string `json:"token"`
+	// Fiat currency. Default: "USD"
+	Currency string `json:"currency"`
+	// Type of the price provider. Supported types: "file"
+	Type string `json:"type"`
+	// Resolution of the candles. Candle times must be aligned to it (in UTC). Default: "1D"
+	Resolution string `json:"resolution"`
+	// Only for "file" type. CSV or JSON file of candles
+	Path string `json:"path"`
+}
+
+// PriceProvider provides historical fiat reference prices of tokens
+type PriceProvider interface {
+	// FetchCandles returns the candles of the token in the currency on or after since in ascending order
+	FetchCandles(token, currency string, since time.Time) ([]Candle, error)
+}
+
+// Candle contains the prices of a token within a time period
+type Candle struct {
+	// Start of the period
+	Time  time.Time `json:"time"`
+	Open  float64   `json:"open"`
+	High  float64   `json:"high"`
+	Low   float64   `json:"low"`
+	Close float64   `json:"close"`
+}
+
+// priceProviderTypes contains the constructors of all supported price provider types
+var priceProviderTypes = map[string]func(c FiatPriceConfig) (PriceProvider, error){}
+
+// referencePrices contains the imported prices of all configured tokens
+var referencePrices = map[string]*referenceSeries{} // lowercase ticker : prices
+
+// referenceSeries keeps the imported candles of a token in memory as bars
+type referenceSeries struct {
+	config     FiatPriceConfig
+	provider   PriceProvider
+	resolution Resolution
+
+	mutex sync.RWMutex
+	bars  []Bar // ascending order. Replaced as a whole on import.
+}
+
+// setupFiatPrices validates the fiat price configuration and instantiates the price providers
+func setupFiatPrices() error {
+	referencePrices = map[string]*referenceSeries{}
+	for i, c := range conf.FiatPrices {
+		if c.Token == "" {
+			return fmt.Errorf("fiatprices[%d]: token is required", i)
+		}
+		c.Token = strings.ToUpper(c.Token)
+		c.Currency = strings.ToUpper(c.Currency)
+		if c.Currency == "" {
+			c.Currency = defaultFiatCurrency
+		}
+		if c.Resolution == "" {
+			c.Resolution = defaultFiatResolution
+		}
+		res, err := parseResolution(c.Resolution)
+		if err != nil {
+			return fmt.Errorf("fiatprices[%d]: invalid resolution: %s", i, c.Resolution)
+		}
+		newProvider, found := priceProviderTypes[strings.ToLower(c.Type)]
+		if !found {
+			return fmt.Errorf("fiatprices[%d]: unsupported type: %s", i, c.Type)
+		}
+		provider, err := newProvider(c)
+		if err != nil {
+			return fmt.Errorf("fiatprices[%d]: %v", i, err)
+		}
+		ticker := strings.ToLower(referenceTicker(c.Token, c.Currency))
+		if referencePrices[ticker] != nil {
+			return fmt.Errorf("fiatprices[%d]: duplicate token and currency: %s/%s", i, c.Token, c.Currency)
+		}
+		conf.FiatPrices[i] = c
+		referencePrices[ticker] = &referenceSeries{config: c, provider: provider, resolution: res}
+	}
+	return nil
+}
+
+// referenceTicker returns the ticker of the reference prices of a token. Eg: "Reference:ETH/USD"
+func referenceTicker(token, currency string) string {
+	return referenceExchange + ":" + token + "/" + currency
+}
+
+// newReferenceSymbols creates the symbols of the configured reference prices.
+// Bars are aligned to UTC, as are the candles.
+func newReferenceSymbols() (list []Symbol) {
+	for _, c := range conf.FiatPrices {
+		name := c.Token + "/" + c.Currency
+		s := newSymbol(
+			referenceExchange,
+			name,
+			referenceTicker(c.Token, c.Currency),
+			fmt.Sprintf("%s reference price in %s", c.Token, c.Currency),
+			"", "",
+			c.Currency)
+		s.Type = symbolTypeReference
+		s.HasNoVolume = true
+		s.setCalendar(defaultSession, defaultTimeZone, nil)
+		list = append(list, s)
+		log.Println("Adding reference price: ", s.Ticker)
+	}
+	return
+}
+
+// fiatSyntheticPairs returns a synthetic pair in the fiat currency for each traded symbol of a token with reference prices.
+// Eg: "HALO/USD" from HALO/ETH and ETH/USD. Configured synthetic pairs with the same name take precedence.
+func fiatSyntheticPairs(list []Symbol) (pairs []SyntheticPair) {
+	names := map[string]bool{}
+	for _, p := range conf.SyntheticPairs {
+		names[strings.ToLower(p.Name)] = true
+	}
+	for _, s := range list {
+		if !s.isTraded() {
+			continue
+		}
+		for _, c := range conf.FiatPrices {
+			if !strings.EqualFold(s.BaseTicker, c.Token) {
+				continue
+			}
+			name := s.market().QuoteTicker + "/" + c.Currency
+			if names[strings.ToLower(name)] {
+				continue
+			}
+			names[strings.ToLower(name)] = true
+			pairs = append(pairs, SyntheticPair{
+				Name:        name,
+				Description: fmt.Sprintf("%s in %s", s.Description, c.Currency),
+				Legs: []SyntheticLeg{
+					{Symbol: s.Ticker},
+					{Symbol: referenceTicker(c.Token, c.Currency)},
+				},
+			})
+		}
+	}
+	return
+}
+
+// importFiatPrices retrieves the new candles of all reference prices.
+// Bar updates of the synthetic pairs using them are published.
+func importFiatPrices() {
+	for ticker, series := range referencePrices {
+		if !syncing.TryLock(ticker) {
+			continue
+		}
+		prevSynthetic := syntheticBarsSnapshot(ticker)
+		count, err := series.Import()
+		syncing.Unlock(ticker)
+		if err != nil {
+			log.Println("Failed to import reference prices", ticker, err)
+			continue
+		}
+		if count == 0 {
+			continue
+		}
+		log.Println("Reference prices imported: ", ticker, count)
+		publishSyntheticUpdates(ticker, prevSynthetic)
+	}
+}
+
+// Import appends the candles after the last imported one. Returns the number of new candles.
+func (s *referenceSeries) Import() (count int, err error) {
+	prev := s.Bars()
+	since := time.Time{}
+	if n := len(prev); n > 0 {
+		since = prev[n-1].Time.Add(time.Nanosecond)
+	}
+	candles, err := s.provider.FetchCandles(s.config.Token, s.config.Currency, since)
+	if err != nil {
+		return
+	}
+	// Copy to keep the previous snapshot unchanged
+	bars := append([]Bar{}, prev...)
+	for _, c := range candles {
+		start := s.resolution.Start(c.Time)
+		if n := len(bars); n > 0 && !start.After(bars[n-1].Time) {
+			// not in ascending order or not aligned to the resolution
+			continue
+		}
+		bars = append(bars, Bar{
+			Time:         start,
+			TimeEnd:      s.resolution.End(start),
+			UnixTime:     start.Unix(),
+			OpeningPrice: c.Open,
+			HighPrice:    c.High,
+			LowPrice:     c.Low,
+			ClosingPrice: c.Close,
+		})
+		count++
+	}
+	if count > 0 {
+		s.mutex.Lock()
+		s.bars = bars
+		s.mutex.Unlock()
+	}
+	return
+}
+
+// Bars returns the imported candles as bars of the candle resolution. Must not be modified.
+func (s *referenceSeries) Bars() []Bar {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.bars
+}
+
+// getReferenceResolution returns all bars of a resolution of a reference price symbol.
+// Bars of larger resolutions are aggregated from the candles.
+// Bars of smaller resolutions only contain the opening price of each candle, at the start of the candle.
+func getReferenceResolution(symbol Symbol, resolution string) (bars []Bar, err error) {
+	series, found := referencePrices[strings.ToLower(symbol.Ticker)]
+	if !found {
+		return nil, nil
+	}
+	candles := series.Bars()
+	key := barCacheKey(symbol.Ticker, resolution)
+	if bars, found := derivedBars.Get(key, candles); found {
+		return bars, nil
+	}
+	target, err := parseResolution(resolution)
+	if err != nil {
+		return
+	}
+	switch {
+	case target.Minutes() == series.resolution.Minutes():
+		bars = candles
+	case target.CanDeriveFrom(series.resolution):
+		bars = aggregateBars(candles, target)
+	case series.resolution.CanDeriveFrom(target):
+		for _, c := range candles {
+			bars = append(bars, Bar{
+				Time:         c.Time,
+				TimeEnd:      target.End(c.Time),
+				UnixTime:     c.UnixTime,
+				OpeningPrice: c.OpeningPrice,
+				HighPrice:    c.OpeningPrice,
+				LowPrice:     c.OpeningPrice,
+				ClosingPrice: c.OpeningPrice,
+			})
+		}
+	}
+	derivedBars.Add(key, bars, candles)
+	return
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// Price provider type reading candles from a local file
+const priceProviderTypeFile = "file"
+
+func init() {
+	priceProviderTypes[priceProviderTypeFile] = func(c FiatPriceConfig) (PriceProvider, error) {
+		if c.Path == "" {
+			return nil, fmt.Errorf("path is required for %s prices", c.Token)
+		}
+		return &filePriceProvider{path: c.Path}, nil
+	}
+}
+
+// filePriceProvider reads the candles of a single token from a CSV or JSON file.
+// The file is read again on each import, to pick up appended candles.
+//
+// CSV (".csv"): time,open,high,low,close per line. A header line is optional.
+// Time is either RFC3339 or Unix Epoch seconds. Further columns are ignored.
+//
+// JSON: array of candles. Eg: [{"time": "2019-01-01T00:00:00Z", "open": 140.5, "high": 141, "low": 139.9, "close": 140.2}]
+//
+// Only candles up to the current server time are returned, to be able to replay the history.
+type filePriceProvider struct {
+	path string
+}
+
+func (p *filePriceProvider) FetchCandles(token, currency string, since time.Time) (candles []Candle, err error) {
+	txt, err := client.ReadFile(p.path)
+	if err != nil {
+		return
+	}
+	all := []Candle{}
+	if strings.EqualFold(filepath.Ext(p.path), ".csv") {
+		all, err = parseCandlesCSV(txt)
+	} else {
+		err = json.Unmarshal([]byte(txt), &all)
+	}
+	if err != nil {
+		return
+	}
+	now := clock.Now()
+	for _, c := range all {
+		if c.Time.Before(since) || c.Time.After(now) {
+			continue
+		}
+		candles = append(candles, c)
+	}
+	sort.SliceStable(candles, func(i, j int) bool { return candles[i].Time.Before(candles[j].Time) })
+	return
+}
+
+// parseCandlesCSV parses lines of time,open,high,low,close
+func parseCandlesCSV(txt string) (candles []Candle, err error) {
+	reader := csv.NewReader(strings.NewReader(txt))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return
+	}
+	for i, record := range records {
+		if len(record) < 5 {
+			return nil, fmt.Errorf("line %d: expected time,open,high,low,close", i+1)
+		}
+		t, err := parseCandleTime(record[0])
+		if err != nil {
+			if i == 0 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid time: %s", i+1, record[0])
+		}
+		c := Candle{Time: t}
+		for j, price := range []*float64{&c.Open, &c.High, &c.Low, &c.Close} {
+			if *price, err = strconv.ParseFloat(strings.TrimSpace(record[j+1]), 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid price: %s", i+1, record[j+1])
+			}
+		}
+		candles = append(candles, c)
+	}
+	return
+}
+
+// parseCandleTime parses RFC3339 or Unix Epoch seconds
+func parseCandleTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
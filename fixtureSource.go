@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// Trade source type for offline use
+const sourceTypeFixture = "fixture"
+
+func init() {
+	sourceTypes[sourceTypeFixture] = func(c SourceConfig) (TradeSource, error) {
+		if c.Path == "" {
+			return nil, fmt.Errorf("Fixture source %s: path is required", c.Name)
+		}
+		return &fixtureSource{dir: c.Path}, nil
+	}
+}
+
+// fixtureSource serves markets and trades from fixture files to run the feed offline.
+// Directory layout:
+//
+//	<path>/markets.json                   : array of markets
+//	<path>/trades/<QUOTE>-<BASE>.json     : array of trades of the market in any order
+//	<path>/orderbooks/<QUOTE>-<BASE>.json : order book of the market (optional)
+//
+// Only trades up to the current server time are returned. Combined with a ManualClock,
+// this replays the trade history as if the trades were happening live.
+type fixtureSource struct {
+	dir string
+}
+
+func (s *fixtureSource) ListMarkets() (markets []Market, err error) {
+	txt, err := client.ReadFile(s.dir + "/markets.json")
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(txt), &markets)
+	return
+}
+
+func (s *fixtureSource) marketFile(dir string, market Market) string {
+	return fmt.Sprintf("%s/%s/%s-%s.json", s.dir, dir,
+		strings.ToUpper(market.QuoteTicker), strings.ToUpper(market.BaseTicker))
+}
+
+func (s *fixtureSource) FetchTradesSince(market Market, since time.Time) (trades []client.Trade, err error) {
+	txt, err := client.ReadFile(s.marketFile("trades", market))
+	if err != nil {
+		// market without trades
+		return nil, nil
+	}
+	all := []client.Trade{}
+	if err = json.Unmarshal([]byte(txt), &all); err != nil {
+		return
+	}
+	now := clock.Now()
+	for _, t := range all {
+		if t.Time.Before(since) || t.Time.After(now) {
+			continue
+		}
+		trades = append(trades, t)
+	}
+	// decending order
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].Time.After(trades[j].Time) })
+	return
+}
+
+func (s *fixtureSource) FetchOrderBook(market Market, depth int) (book OrderBook, err error) {
+	txt, err := client.ReadFile(s.marketFile("orderbooks", market))
+	if err != nil {
+		// market without orders
+		return OrderBook{}, nil
+	}
+	if err = json.Unmarshal([]byte(txt), &book); err != nil {
+		return
+	}
+	sort.SliceStable(book.Bids, func(i, j int) bool { return book.Bids[i].Price > book.Bids[j].Price })
+	sort.SliceStable(book.Asks, func(i, j int) bool { return book.Asks[i].Price < book.Asks[j].Price })
+	if depth > 0 && len(book.Bids) > depth {
+		book.Bids = book.Bids[:depth]
+	}
+	if depth > 0 && len(book.Asks) > depth {
+		book.Asks = book.Asks[:depth]
+	}
+	return
+}
@@ -1,14 +1,10 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
+	"math"
 	"net/http"
-	"strconv"
 	"strings"
-
-	"github.com/alien45/halo-info-bot/client"
 )
 
 const historyStatusNoData = "no_data"
@@ -16,7 +12,6 @@ const historyStatusOk = "ok"
 const historyStatusError = "error"
 
 var resolutionCache map[string][]Bar
-var cachedBars map[string]map[string][]Bar // Symbol : resolution : []Bar
 
 // History as described here: https://github.com/tradingview/charting_library/wiki/UDF#bars
 type History struct {
@@ -31,34 +26,84 @@ type History struct {
 	HighPrice    []float64 `json:"h"`
 	LowPrice     []float64 `json:"l"`
 	Volume       []float64 `json:"v"`
+	// Only in extended mode. See Bar.
+	QuoteVolume []float64 `json:"qv,omitempty"`
+	Trades      []int     `json:"n,omitempty"`
+	BuyVolume   []float64 `json:"bv,omitempty"`
+	SellVolume  []float64 `json:"sv,omitempty"`
+	VWAP        []float64 `json:"vwap,omitempty"`
 	// Unix Epoch time of the next bar.
 	// Only if status == no_data
 	NextTime int64 `json:"nextTime"`
 }
 
+// historyHandler responds with bars within the time range
+// GET Params:
+// @symbol     name or "EXCHANGE:NAME"
+// @resolution
+// @from       Unix Epoch seconds
+// @to         Unix Epoch seconds
+// @countback  (optional) number of bars ending at `to`. If set, `from` is ignored.
+// @extended   (optional) "1" or "true" to include quote volume (qv), number of trades (n),
+// buy volume (bv), sell volume (sv) and VWAP (vwap) of each bar
 func historyHandler(w http.ResponseWriter, r *http.Request) {
-	params := r.URL.Query()
-	symbol := strings.ToLower(params["symbol"][0])
-	resolution := params["resolution"][0]
-	log.Println("Resolution:", resolution)
-	from, _ := strconv.ParseInt(params["from"][0], 0, 64)
-	to, _ := strconv.ParseInt(params["to"][0], 0, 64)
+	params := newRequestParams(r)
+	symbol := strings.ToLower(params.Symbol("symbol").Ticker)
+	resolution := params.Resolution("resolution")
+	from := params.Int64("from", true)
+	to := params.Int64("to", true)
+	countback := params.Int("countback", false)
+	extendedStr := params.String("extended", false)
+	extended := extendedStr == "1" || strings.EqualFold(extendedStr, "true")
+	if params.Valid() && countback == 0 && from > to {
+		params.Invalidate("from must not be after to", err400)
+	}
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	if now := clock.Now().Unix(); to > now {
+		to = now
+	}
 	h := History{}
 	h.Status = historyStatusOk
 	nextTime := int64(0)
-	bars, err := getResolution(symbol, resolution)
-	if respondIfError(err, w, "Failed to read file or symbol not found", err500) {
+	var bars []Bar
+	var err error
+	if countback > 0 {
+		bars, err = queryLastBars(symbol, resolution, to, countback)
+	} else {
+		bars, err = queryBars(symbol, resolution, from, to)
+	}
+	if err != nil {
+		log.Println("[history] failed to read bars", symbol, resolution, err)
+		respondUDFError(w, "Failed to read bars", err500)
 		return
 	}
+	if len(bars) == 0 && countback == 0 {
+		// Let the chart know where to continue scrolling back to
+		prev, err := queryLastBars(symbol, resolution, from-1, 1)
+		if err != nil {
+			log.Println("[history] failed to read bars", symbol, resolution, err)
+			respondUDFError(w, "Failed to read bars", err500)
+			return
+		}
+		if len(prev) > 0 {
+			nextTime = prev[0].UnixTime
+		}
+	}
 	for i := 0; i < len(bars); i++ {
-		t := bars[i].UnixTime
-		if t >= from && t <= to {
-			h.BarTime = append(h.BarTime, t)
-			h.ClosingPrice = append(h.ClosingPrice, bars[i].ClosingPrice)
-			h.OpeningPrice = append(h.OpeningPrice, bars[i].OpeningPrice)
-			h.HighPrice = append(h.HighPrice, bars[i].HighPrice)
-			h.LowPrice = append(h.LowPrice, bars[i].LowPrice)
-			h.Volume = append(h.Volume, bars[i].Volume)
+		h.BarTime = append(h.BarTime, bars[i].UnixTime)
+		h.ClosingPrice = append(h.ClosingPrice, bars[i].ClosingPrice)
+		h.OpeningPrice = append(h.OpeningPrice, bars[i].OpeningPrice)
+		h.HighPrice = append(h.HighPrice, bars[i].HighPrice)
+		h.LowPrice = append(h.LowPrice, bars[i].LowPrice)
+		h.Volume = append(h.Volume, bars[i].Volume)
+		if extended {
+			h.QuoteVolume = append(h.QuoteVolume, bars[i].QuoteVolume)
+			h.Trades = append(h.Trades, bars[i].Trades)
+			h.BuyVolume = append(h.BuyVolume, bars[i].BuyVolume)
+			h.SellVolume = append(h.SellVolume, bars[i].SellVolume)
+			h.VWAP = append(h.VWAP, bars[i].VWAP)
 		}
 	}
 
@@ -69,23 +114,52 @@ func historyHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, h, ok200)
 }
 
+// queryBars returns bars within the time range of a stored or derived resolution
+func queryBars(symbol, resolution string, from, to int64) ([]Bar, error) {
+	if isStoredResolution(symbol, resolution) {
+		return store.QueryBars(symbol, resolution, from, to)
+	}
+	bars, err := getResolution(symbol, resolution)
+	return sliceBars(bars, from, to), err
+}
+
+// queryLastBars returns up to count latest bars on or before `to` of a stored or derived resolution
+func queryLastBars(symbol, resolution string, to int64, count int) ([]Bar, error) {
+	if isStoredResolution(symbol, resolution) {
+		return store.LastBars(symbol, resolution, to, count)
+	}
+	bars, err := getResolution(symbol, resolution)
+	return lastBars(sliceBars(bars, math.MinInt64, to), count), err
+}
+
+// isStoredResolution checks if the bars of the symbol and resolution are stored
+func isStoredResolution(symbol, resolution string) bool {
+	s, found := symbols.Get(symbol)
+	return isSupportedResolution(resolution) && (!found || s.isTraded())
+}
+
+// getResolution returns all bars of the resolution from cache or storage.
+// Bars of resolutions that are not stored are derived from a stored resolution.
+// Bars of synthetic symbols are calculated from the bars of their legs,
+// and the bars of reference price symbols from the imported candles.
 func getResolution(symbol, resolution string) (bars []Bar, err error) {
-	if cachedBars == nil {
-		cachedBars = map[string]map[string][]Bar{}
+	if s, found := symbols.Get(symbol); found {
+		switch s.Type {
+		case symbolTypeSynthetic:
+			return getSyntheticResolution(s, resolution)
+		case symbolTypeReference:
+			return getReferenceResolution(s, resolution)
+		}
 	}
-	if cachedBars[symbol] == nil {
-		cachedBars[symbol] = map[string][]Bar{}
+	if !isSupportedResolution(resolution) {
+		return getDerivedResolution(symbol, resolution)
 	}
-	if bars, exists := cachedBars[symbol][resolution]; exists {
+	if bars, exists := cachedBars.Get(symbol, resolution); exists {
 		return bars, nil
 	}
-	log.Printf("getResolution() Loading bars from storage: %s/%s", symbol, resolution)
-	filename := fmt.Sprintf("%s/%s/%s.json", dataRootDir, symbol, resolution)
-	jsonStr, err := client.ReadFile(filename)
+	bars, err = loadAllBars(store, symbol, resolution)
 	if err != nil {
 		return
 	}
-	err = json.Unmarshal([]byte(jsonStr), &bars)
-	cachedBars[symbol][resolution] = bars
-	return
+	return cachedBars.SetIfAbsent(symbol, resolution, bars), nil
 }
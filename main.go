@@ -15,29 +15,43 @@ import (
 const ok200 = http.StatusOK
 const created201 = http.StatusCreated
 const err400 = http.StatusBadRequest
+const err401 = http.StatusUnauthorized
 const err404 = http.StatusNotFound
+const err405 = http.StatusMethodNotAllowed
 const err500 = http.StatusInternalServerError
 const err501 = http.StatusNotImplemented
-const configFile = "./config.json"
 const dataRootDir = "./data"
 
+var configFile = "./config.json"
 var err error
-var dex client.DEX
 var syncIntervalMins int // Sync trades every x minutes
 var conf Config
-var resolutions []string // sypported bar resolutions
-var resolutionMins []int // bar resolution in minutes. Used when generating bars
-var symbols []Symbol     // Supported symbols
+var resolutions []string         // sypported bar resolutions
+var resolutionSpecs []Resolution // bar resolutions. Used when generating bars
+var symbols = &symbolRegistry{}  // Supported symbols
+var cachedBars = newBarCache()
+var syncing = &syncGuard{} // Tickers being synced
 
 // Config describes cofigurations and settings
 type Config struct {
-	HaloDEX            client.DEX  `json:"halodex"`
-	SyncIntervalMins   int         `json:"syncintervalmins"`
-	ChartConfig        ChartConfig `json:"chartconfig"`
-	SplitTicker        string      `json:"splitticker"`
-	PreSplitTime       time.Time   `json:"presplittime"`
-	SplitAmount        float64     `json:"splitamount"`
-	IgnoreTradesBefore time.Time   `json:"ignoretradesbefore"`
+	HaloDEX          client.DEX        `json:"halodex"`
+	Sources          []SourceConfig    `json:"sources"`
+	SyncIntervalMins int               `json:"syncintervalmins"`
+	ChartConfig      ChartConfig       `json:"chartconfig"`
+	CorporateActions []CorporateAction `json:"corporateactions"`
+	// Deprecated: use CorporateActions
+	SplitTicker        string            `json:"splitticker"`
+	PreSplitTime       time.Time         `json:"presplittime"`
+	SplitAmount        float64           `json:"splitamount"`
+	IgnoreTradesBefore time.Time         `json:"ignoretradesbefore"`
+	Store              StoreConfig       `json:"store"`
+	Replay             ReplayConfig      `json:"replay"`
+	Symbols            []SymbolConfig    `json:"symbols"`
+	SyntheticPairs     []SyntheticPair   `json:"syntheticpairs"`
+	FiatPrices         []FiatPriceConfig `json:"fiatprices"`
+	AutoMarks          AutoMarksConfig   `json:"automarks"`
+	// Token required by the admin API. Admin API is disabled if empty.
+	AdminToken string `json:"admintoken"`
 }
 
 // ChartConfig ...
@@ -47,38 +61,82 @@ type ChartConfig struct {
 	Marks          bool     `json:"supports_marks"`
 	Search         bool     `json:"supports_search"`
 	TimescaleMarks bool     `json:"supports_timescale_marks"`
+	Time           bool     `json:"supports_time"`
 }
 
 func main() {
+	// Usage: halodex-chart-feed [port] [config file]
+	args := os.Args[1:]
+	port := "3000"
+	if len(args) > 0 {
+		port = args[0]
+	}
+	if len(args) > 1 {
+		configFile = args[1]
+	}
 	jsonStr, err := client.ReadFile(configFile)
 	panicIf(err, "Failed to read config file: "+configFile)
 	err = json.Unmarshal([]byte(jsonStr), &conf)
 	panicIf(err, "Failed to unmarshal config json")
-	dex = conf.HaloDEX
+	setup()
+	registerHanders(handlers())
+
+	go syncTradesInterval(true)
+	log.Println("HaloDEX chart data feed server started at port ", port)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
+
+// setup applies the configuration, opens the store and retrieves the symbols of the trade sources
+func setup() {
+	err := setupSources()
+	panicIf(err, "Failed to setup trade sources")
 	syncIntervalMins = conf.SyncIntervalMins
+	setupReplay(conf.Replay)
+	// Server time is always available through the "/time" endpoint
+	conf.ChartConfig.Time = true
 	setupResolutions()
+	err = setupSymbolConfigs()
+	panicIf(err, "Invalid symbol configuration")
+	err = setupCorporateActions()
+	panicIf(err, "Invalid corporate actions")
+	err = setupSyntheticPairs()
+	panicIf(err, "Invalid synthetic pairs")
+	err = setupFiatPrices()
+	panicIf(err, "Invalid fiat prices")
+	if len(conf.CorporateActions) > 0 || conf.AutoMarks.Enabled() {
+		// Corporate actions and automatic marks are displayed as marks
+		conf.ChartConfig.Marks = true
+	}
+	store, err = openStore(conf.Store)
+	panicIf(err, "Failed to open store")
 	// Update supported tickers/symbols
 	updateSymbols()
-	// Register http handlers
-	registerHanders(map[string]func(http.ResponseWriter, *http.Request){
+}
+
+// handlers returns the http handlers by path
+func handlers() map[string]func(http.ResponseWriter, *http.Request) {
+	return map[string]func(http.ResponseWriter, *http.Request){
 		// TradingView chart configuration data
 		"/config": func(w http.ResponseWriter, r *http.Request) {
 			respondJSON(w, conf.ChartConfig, ok200)
 		},
-		"/symbol_info": respondNotImplemented,
-		"/symbols":     symbolsHandler,
-		"/search":      searchHandler,
-		"/history":     historyHandler,
-	})
-
-	args := os.Args[1:]
-	port := "3000"
-	if len(args) > 0 {
-		port = args[0]
+		"/symbol_info":     symbolInfoHandler,
+		"/symbols":         symbolsHandler,
+		"/search":          searchHandler,
+		"/history":         historyHandler,
+		"/time":            timeHandler,
+		"/stream":          streamHandler,
+		"/events":          eventsHandler,
+		"/marks":           marksHandler,
+		"/timescale_marks": timescaleMarksHandler,
+		"/admin/marks":     adminMarksHandler,
+		"/quotes":          quotesHandler,
+		// Market data aggregator API (CoinGecko format)
+		"/aggregator/pairs":             aggregatorPairsHandler,
+		"/aggregator/tickers":           aggregatorTickersHandler,
+		"/aggregator/orderbook":         aggregatorOrderBookHandler,
+		"/aggregator/historical_trades": aggregatorTradesHandler,
 	}
-	go syncTradesInterval(true)
-	log.Println("HaloDEX chart data feed server started at port ", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
 
 func syncTradesInterval(execOnInit bool) {
@@ -86,14 +144,19 @@ func syncTradesInterval(execOnInit bool) {
 		syncTrades()
 	}
 	// Execute on interval
-	for range time.Tick(time.Minute * time.Duration(conf.SyncIntervalMins)) {
+	for range clock.Tick(time.Minute * time.Duration(conf.SyncIntervalMins)) {
 		go syncTrades()
 	}
 }
 
 func syncTrades() {
-	for _, symbol := range symbols {
-		sync(symbol.Ticker, true)
+	importFiatPrices()
+	for _, symbol := range symbols.List() {
+		if !symbol.isTraded() {
+			// bars are calculated from other symbols or reference prices
+			continue
+		}
+		syncTicker(symbol.Ticker, true)
 	}
 }
 
@@ -107,7 +170,13 @@ func allowCORS(handler func(w http.ResponseWriter, r *http.Request)) func(w http
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[request] %s | [ip] %s", r.URL.RequestURI(), r.RemoteAddr)
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,access-control-allow-origin, access-control-allow-headers")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,access-control-allow-origin, access-control-allow-headers")
+		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
+		if r.Method == http.MethodOptions {
+			// CORS preflight request
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		handler(w, r)
 	}
 }
@@ -123,8 +192,8 @@ func respondJSON(w http.ResponseWriter, content interface{}, statusCode int) {
 	if respondIfError(err, w, "Something went wrong!", err500) {
 		return
 	}
-	w.WriteHeader(statusCode)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 	_, err = w.Write(b)
 	if err != nil {
 		log.Println("[response] [error]", err)
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Mark is an annotation displayed on the bars of a chart
+type Mark struct {
+	ID   string `json:"id"`
+	Time int64  `json:"time"` // Unix Epoch seconds
+	// Name or hex code. Eg: "red" or "#FF0000"
+	Color string `json:"color"`
+	// Displayed on hover
+	Text string `json:"text"`
+	// Single character displayed on the mark
+	Label          string `json:"label"`
+	LabelFontColor string `json:"labelFontColor"`
+	MinSize        int    `json:"minSize"`
+}
+
+// Marks describes a list of marks in the column-oriented format as described here:
+// https://github.com/tradingview/charting_library/wiki/UDF#marks
+type Marks struct {
+	ID             []string `json:"id"`
+	Time           []int64  `json:"time"`
+	Color          []string `json:"color"`
+	Text           []string `json:"text"`
+	Label          []string `json:"label"`
+	LabelFontColor []string `json:"labelFontColor"`
+	MinSize        []int    `json:"minSize"`
+}
+
+// newMarks converts a list of marks to the column-oriented format
+func newMarks(list []Mark) (marks Marks) {
+	marks = Marks{
+		ID:             []string{},
+		Time:           []int64{},
+		Color:          []string{},
+		Text:           []string{},
+		Label:          []string{},
+		LabelFontColor: []string{},
+		MinSize:        []int{},
+	}
+	for _, m := range list {
+		marks.ID = append(marks.ID, m.ID)
+		marks.Time = append(marks.Time, m.Time)
+		marks.Color = append(marks.Color, m.Color)
+		marks.Text = append(marks.Text, m.Text)
+		marks.Label = append(marks.Label, m.Label)
+		marks.LabelFontColor = append(marks.LabelFontColor, m.LabelFontColor)
+		marks.MinSize = append(marks.MinSize, m.MinSize)
+	}
+	return
+}
+
+// TimescaleMark is an annotation displayed on the time scale of a chart as described here:
+// https://github.com/tradingview/charting_library/wiki/UDF#timescale-marks
+type TimescaleMark struct {
+	ID    string `json:"id"`
+	Time  int64  `json:"time"` // Unix Epoch seconds
+	Color string `json:"color"`
+	// Single character displayed on the mark
+	Label string `json:"label"`
+	// Lines of text displayed on hover
+	Tooltip []string `json:"tooltip"`
+}
+
+// corporateActionMarks returns the corporate actions of the symbol's tokens as marks
+func corporateActionMarks(symbol Symbol) (marks []Mark) {
+	for _, a := range corporateActionsOf(symbol) {
+		color := "blue"
+		switch a.Type {
+		case actionReverseSplit:
+			color = "red"
+		case actionMigration:
+			color = "green"
+		}
+		marks = append(marks, Mark{
+			ID:             fmt.Sprintf("%s-%s-%d", a.Type, strings.ToLower(a.Token), a.Time.Unix()),
+			Time:           a.Time.Unix(),
+			Color:          color,
+			Text:           a.Text(),
+			Label:          strings.ToUpper(a.Type[:1]),
+			LabelFontColor: "white",
+			MinSize:        14,
+		})
+	}
+	return
+}
+
+// marksHandler responds with the marks of a symbol within the time range.
+// Corporate actions (splits, migrations etc.) of the symbol's tokens, the
+// stored annotations and the automatically generated marks are included.
+// GET Params:
+// @symbol
+// @from       Unix Epoch seconds
+// @to         Unix Epoch seconds
+// @resolution
+func marksHandler(w http.ResponseWriter, r *http.Request) {
+	params := newRequestParams(r)
+	symbol := params.Symbol("symbol")
+	from := params.Int64("from", true)
+	to := params.Int64("to", true)
+	resolution := params.Resolution("resolution")
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	annotations, err := findAnnotations(symbol, resolution, from, to, false)
+	if err != nil {
+		log.Println("[marks] failed to read annotations", err)
+		respondUDFError(w, "Failed to read marks", err500)
+		return
+	}
+	list, err := autoMarks(symbol, resolution, from, to)
+	if err != nil {
+		log.Println("[marks] failed to generate marks", err)
+		respondUDFError(w, "Failed to read marks", err500)
+		return
+	}
+	for _, m := range corporateActionMarks(symbol) {
+		if m.Time >= from && m.Time <= to {
+			list = append(list, m)
+		}
+	}
+	for _, a := range annotations {
+		list = append(list, a.Mark())
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time < list[j].Time })
+	respondJSON(w, newMarks(list), ok200)
+}
+
+// timescaleMarksHandler responds with the time scale marks of a symbol within the time range
+// GET Params:
+// @symbol
+// @from       Unix Epoch seconds
+// @to         Unix Epoch seconds
+// @resolution
+func timescaleMarksHandler(w http.ResponseWriter, r *http.Request) {
+	params := newRequestParams(r)
+	symbol := params.Symbol("symbol")
+	from := params.Int64("from", true)
+	to := params.Int64("to", true)
+	resolution := params.Resolution("resolution")
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	annotations, err := findAnnotations(symbol, resolution, from, to, true)
+	if err != nil {
+		log.Println("[timescale_marks] failed to read annotations", err)
+		respondUDFError(w, "Failed to read marks", err500)
+		return
+	}
+	list := []TimescaleMark{}
+	for _, a := range annotations {
+		list = append(list, a.TimescaleMark())
+	}
+	respondJSON(w, list, ok200)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// UDF error messages
+const errmsgUnknownSymbol = "unknown_symbol"
+const errmsgInvalidResolution = "invalid_resolution"
+
+// UDFError as described here: https://github.com/tradingview/charting_library/wiki/UDF
+type UDFError struct {
+	Status       string `json:"s"` // always "error"
+	ErrorMessage string `json:"errmsg"`
+}
+
+// respondUDFError responds with an error in the UDF format: {"s":"error","errmsg":"..."}
+func respondUDFError(w http.ResponseWriter, msg string, statusCode int) {
+	if statusCode == 0 {
+		statusCode = err400
+	}
+	if msg == "" {
+		msg = http.StatusText(statusCode)
+	}
+	respondJSON(w, UDFError{Status: historyStatusError, ErrorMessage: msg}, statusCode)
+}
+
+// requestParams validates and converts query parameters of UDF requests.
+// Only the first error is kept and later validations are skipped.
+// Usage:
+//
+//	p := newRequestParams(r)
+//	symbol := p.Symbol("symbol")
+//	from := p.Int64("from", true)
+//	if p.RespondIfInvalid(w) {
+//		return
+//	}
+type requestParams struct {
+	values     url.Values
+	err        string
+	statusCode int
+	symbol     Symbol // last symbol validated
+}
+
+func newRequestParams(r *http.Request) *requestParams {
+	return &requestParams{values: r.URL.Query()}
+}
+
+// Invalidate sets the error if there is none yet
+func (p *requestParams) Invalidate(msg string, statusCode int) {
+	if p.err != "" {
+		return
+	}
+	p.err = msg
+	p.statusCode = statusCode
+}
+
+// Valid checks if all params validated so far are valid
+func (p *requestParams) Valid() bool {
+	return p.err == ""
+}
+
+// RespondIfInvalid responds with the UDF error if any param is invalid
+func (p *requestParams) RespondIfInvalid(w http.ResponseWriter) bool {
+	if p.Valid() {
+		return false
+	}
+	respondUDFError(w, p.err, p.statusCode)
+	return true
+}
+
+// String returns the trimmed param value
+func (p *requestParams) String(name string, required bool) (value string) {
+	value = strings.TrimSpace(p.values.Get(name))
+	if value == "" && required {
+		p.Invalidate(fmt.Sprintf("%s is required", name), err400)
+	}
+	return
+}
+
+// Int64 returns the param as integer. Returns 0 if missing and not required.
+func (p *requestParams) Int64(name string, required bool) (value int64) {
+	str := p.String(name, required)
+	if str == "" || !p.Valid() {
+		return
+	}
+	value, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		p.Invalidate(fmt.Sprintf("%s must be an integer", name), err400)
+	}
+	return
+}
+
+// Int returns the param as a non-negative integer. Returns 0 if missing and not required.
+func (p *requestParams) Int(name string, required bool) int {
+	value := p.Int64(name, required)
+	if value < 0 || value != int64(int(value)) {
+		p.Invalidate(fmt.Sprintf("%s must be a positive integer", name), err400)
+		return 0
+	}
+	return int(value)
+}
+
+// Symbol returns the symbol by name, ticker or "EXCHANGE:TICKER"
+func (p *requestParams) Symbol(name string) (symbol Symbol) {
+	symbolStr := p.String(name, true)
+	if !p.Valid() {
+		return
+	}
+	symbol, found := findSymbol(symbolStr)
+	if !found {
+		p.Invalidate(errmsgUnknownSymbol, err404)
+	}
+	p.symbol = symbol
+	return
+}
+
+// Resolution returns the resolution if it is stored or can be derived from a stored resolution.
+// Must be validated after the symbol, as derivable resolutions depend on the symbol's timezone.
+func (p *requestParams) Resolution(name string) (resolution string) {
+	resolution = p.String(name, true)
+	if p.Valid() && !isAvailableResolution(resolution, p.symbol.Location()) {
+		p.Invalidate(errmsgInvalidResolution, err400)
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestParamsErrors(t *testing.T) {
+	startTestFeed(t)
+	history := "symbol=HALO/ETH&resolution=60&from=1546300800&to=1546387200"
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		query   string
+		status  int
+		errmsg  string
+	}{
+		{"missing symbol", historyHandler, "resolution=60&from=1546300800&to=1546387200", err400, "symbol is required"},
+		{"blank symbol", symbolsHandler, "symbol=%20", err400, "symbol is required"},
+		{"missing resolution", historyHandler, "symbol=HALO/ETH&from=1546300800&to=1546387200", err400, "resolution is required"},
+		{"missing from", historyHandler, "symbol=HALO/ETH&resolution=60&to=1546387200", err400, "from is required"},
+		{"missing to", historyHandler, "symbol=HALO/ETH&resolution=60&from=1546300800", err400, "to is required"},
+		{"non-integer from", historyHandler, "symbol=HALO/ETH&resolution=60&from=2019-01-01&to=1546387200", err400, "from must be an integer"},
+		{"non-integer to", historyHandler, "symbol=HALO/ETH&resolution=60&from=1546300800&to=1.5", err400, "to must be an integer"},
+		{"negative countback", historyHandler, history + "&countback=-1", err400, "countback must be a positive integer"},
+		{"negative limit", searchHandler, "query=halo&limit=-1", err400, "limit must be a positive integer"},
+		{"unknown symbol", historyHandler, "symbol=XYZ/ETH&resolution=60&from=1546300800&to=1546387200", err404, errmsgUnknownSymbol},
+		{"unknown symbol info", symbolsHandler, "symbol=XYZ/ETH", err404, errmsgUnknownSymbol},
+		{"unknown exchange", symbolsHandler, "symbol=Other:HALO/ETH", err404, errmsgUnknownSymbol},
+		{"unknown exchange history", historyHandler, "symbol=Other:HALO/ETH&resolution=60&from=1546300800&to=1546387200", err404, errmsgUnknownSymbol},
+		{"unknown resolution", historyHandler, "symbol=HALO/ETH&resolution=7X&from=1546300800&to=1546387200", err400, errmsgInvalidResolution},
+		{"underivable resolution", historyHandler, "symbol=HALO/ETH&resolution=7&from=1546300800&to=1546387200", err400, errmsgInvalidResolution},
+		{"EXCHANGE:TICKER as resolution", historyHandler, "symbol=HaloDEX:HALO/ETH&resolution=HaloDEX:HALO/ETH&from=1546300800&to=1546387200", err400, errmsgInvalidResolution},
+		{"from after to", historyHandler, "symbol=HALO/ETH&resolution=60&from=1546387200&to=1546300800", err400, "from must not be after to"},
+		{"first error is kept", historyHandler, "symbol=XYZ/ETH&from=x&countback=-1", err404, errmsgUnknownSymbol},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+test.query, nil)
+			w := httptest.NewRecorder()
+			test.handler(w, r)
+			if w.Code != test.status {
+				t.Errorf("unexpected status %d, expected %d", w.Code, test.status)
+			}
+			assertJSON(t, w.Body.Bytes(), `{"s":"error","errmsg":"`+test.errmsg+`"}`)
+		})
+	}
+}
+
+func TestRequestParamsValid(t *testing.T) {
+	startTestFeed(t)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		query   string
+	}{
+		{"EXCHANGE:TICKER symbol", historyHandler, "symbol=HaloDEX:HALO/ETH&resolution=60&from=1546300800&to=1546387200"},
+		{"from after to with countback", historyHandler, "symbol=HALO/ETH&resolution=60&from=1546387200&to=1546300800&countback=2"},
+		{"zero countback", historyHandler, "symbol=HALO/ETH&resolution=60&from=1546300800&to=1546387200&countback=0"},
+		{"padded params", historyHandler, "symbol=%20HALO/ETH%20&resolution=%2060&from=1546300800%20&to=1546387200"},
+		{"symbol info", symbolsHandler, "symbol=HaloDEX:HALO/ETH"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+test.query, nil)
+			w := httptest.NewRecorder()
+			test.handler(w, r)
+			if w.Code != ok200 {
+				t.Errorf("unexpected status %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
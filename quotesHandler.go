@@ -0,0 +1,251 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Period of the quote statistics
+const quotePeriod = 24 * time.Hour
+
+// Quotes as described here: https://github.com/tradingview/charting_library/wiki/UDF#quotes
+type Quotes struct {
+	Status       string  `json:"s"`
+	ErrorMessage string  `json:"errmsg,omitempty"`
+	Data         []Quote `json:"d"`
+}
+
+// Quote contains the values of a single symbol
+type Quote struct {
+	Status string `json:"s"`
+	// Symbol as requested
+	Name string `json:"n"`
+	// QuoteValues or an empty object if Status == error
+	Values interface{} `json:"v"`
+}
+
+// QuoteValues describes the last price and statistics of the past 24 hours
+type QuoteValues struct {
+	// Change of the last price since the previous close (price 24 hours ago)
+	Change        float64 `json:"ch"`
+	ChangePercent float64 `json:"chp"`
+	ShortName     string  `json:"short_name"`
+	Exchange      string  `json:"exchange"`
+	Description   string  `json:"description"`
+	LastPrice     float64 `json:"lp"`
+	// Best ask, bid and spread. Only if the trade source provides the order book.
+	Ask            float64 `json:"ask,omitempty"`
+	Bid            float64 `json:"bid,omitempty"`
+	Spread         float64 `json:"spread,omitempty"`
+	OpenPrice      float64 `json:"open_price"`
+	HighPrice      float64 `json:"high_price"`
+	LowPrice       float64 `json:"low_price"`
+	PrevClosePrice float64 `json:"prev_close_price"`
+	Volume         float64 `json:"volume"`
+
+	// 24 hour volume in base token (sum of price * amount)
+	baseVolume float64
+	// Number of trades or bars within the period. Prices can be zero, so they do not tell if there were any.
+	count int
+}
+
+// quotesHandler responds with the last price and 24 hour statistics of the symbols
+// GET Params:
+// @symbols  comma separated symbols. Eg: "HALO/ETH,HaloDEX:HALO/USDT"
+func quotesHandler(w http.ResponseWriter, r *http.Request) {
+	params := newRequestParams(r)
+	symbolsStr := params.String("symbols", true)
+	if params.RespondIfInvalid(w) {
+		return
+	}
+	quotes := Quotes{Status: historyStatusOk, Data: []Quote{}}
+	for _, name := range strings.Split(symbolsStr, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		quote := Quote{Status: historyStatusError, Name: name, Values: struct{}{}}
+		if symbol, found := findSymbol(name); found {
+			values, err := getQuoteValues(symbol)
+			if err != nil {
+				log.Println("[quotes] failed to calculate quote", name, err)
+			} else if values != nil {
+				quote.Status = historyStatusOk
+				quote.Values = values
+			}
+		}
+		quotes.Data = append(quotes.Data, quote)
+	}
+	respondJSON(w, quotes, ok200)
+}
+
+// smallestResolution returns the stored resolution with the shortest duration
+func smallestResolution() (resolution string) {
+	minutes := 0
+	for i, res := range resolutions {
+		if m := resolutionSpecs[i].Minutes(); minutes == 0 || m < minutes {
+			resolution = res
+			minutes = m
+		}
+	}
+	return
+}
+
+// getQuoteValues calculates the quote of the symbol from the stored trades.
+// Returns nil if the symbol has no trades.
+func getQuoteValues(symbol Symbol) (q *QuoteValues, err error) {
+	if !symbol.isTraded() {
+		return getQuoteValuesFromBars(symbol)
+	}
+	ticker := strings.ToLower(symbol.Ticker)
+	now := clock.Now()
+	periodStart := now.Add(-quotePeriod)
+	// Find the closing price before the period using the bars,
+	// and read the trades since the start of the bar containing the period start.
+	since := periodStart
+	prevClose, hasPrevClose := 0.0, false
+	bars, err := store.LastBars(ticker, smallestResolution(), periodStart.Unix(), 2)
+	if err != nil {
+		return
+	}
+	if n := len(bars); n > 0 {
+		since = bars[n-1].Time
+		if n > 1 {
+			prevClose, hasPrevClose = bars[0].ClosingPrice, true
+		}
+	}
+	trades, err := store.TradesSince(ticker, since)
+	if err != nil {
+		return
+	}
+	applyCorporateActions(symbol, trades)
+
+	q = &QuoteValues{
+		ShortName:   symbol.Name,
+		Exchange:    symbol.Exchange,
+		Description: symbol.Description,
+	}
+	for i := len(trades) - 1; i >= 0; i-- {
+		t := trades[i]
+		if t.Time.Before(conf.IgnoreTradesBefore) {
+			continue
+		}
+		if t.Time.After(now) {
+			break
+		}
+		if t.Time.Before(periodStart) {
+			prevClose, hasPrevClose = t.Price, true
+			continue
+		}
+		if q.count == 0 {
+			q.OpenPrice = t.Price
+			q.HighPrice = t.Price
+			q.LowPrice = t.Price
+		}
+		q.HighPrice = math.Max(q.HighPrice, t.Price)
+		q.LowPrice = math.Min(q.LowPrice, t.Price)
+		q.LastPrice = t.Price
+		q.Volume += t.Amount
+		q.baseVolume += t.Price * t.Amount
+		q.count++
+	}
+	if !q.setPrevClose(prevClose, hasPrevClose) {
+		return nil, nil
+	}
+
+	// Best bid and ask, if available
+	source, _ := getSource(symbol.Exchange)
+	if books, ok := source.(OrderBookSource); ok {
+		book, err := books.FetchOrderBook(symbol.market(), 1)
+		if err != nil {
+			log.Println("[quotes] failed to retrieve order book", symbol.Ticker, err)
+			return q, nil
+		}
+		if len(book.Bids) > 0 {
+			q.Bid = book.Bids[0].Price
+		}
+		if len(book.Asks) > 0 {
+			q.Ask = book.Asks[0].Price
+		}
+		if q.Bid > 0 && q.Ask > 0 {
+			q.Spread = q.Ask - q.Bid
+		}
+	}
+	return q, nil
+}
+
+// getQuoteValuesFromBars calculates the quote of a symbol without trades
+// (synthetic and reference price symbols) from the bars of the smallest resolution.
+// Returns nil if the symbol has no bars.
+func getQuoteValuesFromBars(symbol Symbol) (q *QuoteValues, err error) {
+	resolution := smallestResolution()
+	if series, found := referencePrices[strings.ToLower(symbol.Ticker)]; found {
+		// bars of resolutions smaller than the candles only contain the opening prices
+		resolution = series.config.Resolution
+	}
+	bars, err := getResolution(strings.ToLower(symbol.Ticker), resolution)
+	if err != nil {
+		return
+	}
+	now := clock.Now()
+	periodStart := now.Add(-quotePeriod)
+	prevClose, hasPrevClose := 0.0, false
+	q = &QuoteValues{
+		ShortName:   symbol.Name,
+		Exchange:    symbol.Exchange,
+		Description: symbol.Description,
+	}
+	for _, b := range bars {
+		if b.Time.After(now) {
+			break
+		}
+		if b.Time.Before(periodStart) {
+			prevClose, hasPrevClose = b.ClosingPrice, true
+			continue
+		}
+		if q.count == 0 {
+			q.OpenPrice = b.OpeningPrice
+			q.HighPrice = b.HighPrice
+			q.LowPrice = b.LowPrice
+		}
+		q.HighPrice = math.Max(q.HighPrice, b.HighPrice)
+		q.LowPrice = math.Min(q.LowPrice, b.LowPrice)
+		q.LastPrice = b.ClosingPrice
+		q.Volume += b.Volume
+		q.count++
+	}
+	if !q.setPrevClose(prevClose, hasPrevClose) {
+		return nil, nil
+	}
+	return q, nil
+}
+
+// setPrevClose sets the previous closing price, if found, and the change since then.
+// Prices are set to the previous close if there were no trades within the period.
+// The opening price is used as the previous close if there were no trades before the period.
+// Returns false if there is no price at all.
+func (q *QuoteValues) setPrevClose(prevClose float64, found bool) bool {
+	if q.count == 0 {
+		// no trades within the period
+		if !found {
+			return false
+		}
+		q.OpenPrice = prevClose
+		q.HighPrice = prevClose
+		q.LowPrice = prevClose
+		q.LastPrice = prevClose
+	}
+	if !found {
+		prevClose = q.OpenPrice
+	}
+	q.PrevClosePrice = prevClose
+	q.Change = q.LastPrice - prevClose
+	q.ChangePercent = 0
+	if prevClose != 0 {
+		q.ChangePercent = q.Change / prevClose * 100
+	}
+	return true
+}
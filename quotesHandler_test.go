@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+func TestQuoteValuesZeroPrices(t *testing.T) {
+	startTestFeed(t)
+	symbol, found := findSymbol("HALO/USDT")
+	if !found {
+		t.Fatal("symbol not found")
+	}
+	ticker := strings.ToLower(symbol.Ticker)
+	base := `"short_name":"HALO/USDT","exchange":"HaloDEX","description":"Halo Platform"`
+	tests := []struct {
+		name   string
+		trades []client.Trade // decending order
+		want   string
+	}{
+		{
+			"no trades",
+			nil,
+			`null`,
+		},
+		{
+			"trade at price 0 before the period",
+			[]client.Trade{{Time: testNow.Add(-25 * time.Hour), Price: 0, Amount: 10}},
+			`{"ch":0,"chp":0,` + base + `,"lp":0,"open_price":0,"high_price":0,"low_price":0,"prev_close_price":0,"volume":0}`,
+		},
+		{
+			"first trade of the period at price 0",
+			[]client.Trade{
+				{Time: testNow.Add(-2 * time.Hour), Price: 2, Amount: 5},
+				{Time: testNow.Add(-4 * time.Hour), Price: 0, Amount: 10},
+			},
+			`{"ch":2,"chp":0,` + base + `,"lp":2,"open_price":0,"high_price":2,"low_price":0,"prev_close_price":0,"volume":15}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := store.AppendTrades(ticker, test.trades); err != nil {
+				t.Fatal(err)
+			}
+			generateNSaveBars(ticker)
+			q, err := getQuoteValues(symbol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, got, test.want)
+		})
+	}
+}
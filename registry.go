@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// symbolRegistry holds the supported symbols.
+// The list is replaced as a whole and must not be modified after Set.
+type symbolRegistry struct {
+	list atomic.Value // []Symbol
+}
+
+// List returns a snapshot of all symbols. Must not be modified.
+func (r *symbolRegistry) List() []Symbol {
+	list, _ := r.list.Load().([]Symbol)
+	return list
+}
+
+// Set replaces all symbols
+func (r *symbolRegistry) Set(list []Symbol) {
+	r.list.Store(list)
+}
+
+// Get finds symbol by ticker (case-insensitive)
+func (r *symbolRegistry) Get(ticker string) (symbol Symbol, found bool) {
+	for _, s := range r.List() {
+		if strings.EqualFold(s.Ticker, ticker) {
+			return s, true
+		}
+	}
+	return
+}
+
+// barCache keeps the bars of each symbol and resolution in memory.
+// Bar slices are snapshots: they are replaced as a whole by Set and never modified,
+// which allows readers to use them without holding the lock.
+type barCache struct {
+	mutex  sync.RWMutex
+	series map[string][]Bar // ticker|resolution : []Bar
+}
+
+func newBarCache() *barCache {
+	return &barCache{series: map[string][]Bar{}}
+}
+
+func barCacheKey(ticker, resolution string) string {
+	return strings.ToLower(ticker) + "|" + resolution
+}
+
+// Get returns the cached bars. Must not be modified.
+func (c *barCache) Get(ticker, resolution string) (bars []Bar, exists bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	bars, exists = c.series[barCacheKey(ticker, resolution)]
+	return
+}
+
+// Set replaces the bars and returns the previous bars
+func (c *barCache) Set(ticker, resolution string, bars []Bar) (prev []Bar) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := barCacheKey(ticker, resolution)
+	prev = c.series[key]
+	c.series[key] = bars
+	return
+}
+
+// SetIfAbsent caches the bars only if there are no cached bars yet.
+// Prevents lazily loaded bars from replacing bars generated in the meantime.
+// Returns the cached bars.
+func (c *barCache) SetIfAbsent(ticker, resolution string, bars []Bar) []Bar {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := barCacheKey(ticker, resolution)
+	if existing, exists := c.series[key]; exists {
+		return existing
+	}
+	c.series[key] = bars
+	return bars
+}
+
+// syncGuard prevents overlapping sync runs of the same ticker
+type syncGuard struct {
+	locks sync.Map // ticker : *sync.Mutex
+}
+
+// TryLock locks the ticker. Returns false if the ticker is already locked.
+func (g *syncGuard) TryLock(ticker string) bool {
+	lock, _ := g.locks.LoadOrStore(strings.ToLower(ticker), &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		log.Println("Sync already in progress. Skipping:", ticker)
+		return false
+	}
+	return true
+}
+
+// Unlock unlocks the ticker
+func (g *syncGuard) Unlock(ticker string) {
+	if lock, ok := g.locks.Load(strings.ToLower(ticker)); ok {
+		lock.(*sync.Mutex).Unlock()
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolution units
+const (
+	unitMinute = iota
+	unitDay
+	unitWeek
+	unitMonth
+)
+
+// Resolution describes the time period of a bar.
+// Minute resolutions are aligned by truncating time. Daily and larger resolutions
+// follow the calendar of Location (UTC if not set): days start at midnight,
+// weeks on Monday (ISO 8601) and months on the first day of the month.
+type Resolution struct {
+	Name     string
+	Unit     int
+	Count    int
+	Location *time.Location
+}
+
+// parseResolution parses a resolution string.
+// Examples: "60" => 60 minutes, "D" or "1D" => 1 day, "W" => 1 week, "M" => 1 month, "12M" => 1 year
+func parseResolution(resolution string) (r Resolution, err error) {
+	r.Name = resolution
+	countStr := resolution
+	for suffix, unit := range map[string]int{"D": unitDay, "W": unitWeek, "M": unitMonth} {
+		if strings.HasSuffix(resolution, suffix) {
+			r.Unit = unit
+			countStr = strings.TrimSuffix(resolution, suffix)
+			if countStr == "" {
+				// "D", "W" and "M" are same as "1D", "1W" and "1M"
+				countStr = "1"
+			}
+			break
+		}
+	}
+	r.Count, err = strconv.Atoi(countStr)
+	if err == nil && r.Count <= 0 {
+		err = fmt.Errorf("Invalid resolution: %s", resolution)
+	}
+	return
+}
+
+// In returns a copy of the resolution using the calendar of loc
+func (r Resolution) In(loc *time.Location) Resolution {
+	r.Location = loc
+	return r
+}
+
+func (r Resolution) location() *time.Location {
+	if r.Location == nil {
+		return time.UTC
+	}
+	return r.Location
+}
+
+// Minutes returns the (approximate for months) duration of the resolution in minutes
+func (r Resolution) Minutes() int {
+	switch r.Unit {
+	case unitDay:
+		return r.Count * 1440
+	case unitWeek:
+		return r.Count * 10080
+	case unitMonth:
+		return r.Count * 43200
+	}
+	return r.Count
+}
+
+// IsIntraDay checks if resolution is shorter than a day
+func (r Resolution) IsIntraDay() bool {
+	return r.Unit == unitMinute && r.Count < 1440
+}
+
+// alignsWithDays checks if every day (of loc) starts with a new bar of this resolution.
+// Minute bars are aligned to UTC. Outside UTC, they are only assumed to align with
+// local midnight if they also align with any UTC offset (multiples of 15 minutes).
+func (r Resolution) alignsWithDays(loc *time.Location) bool {
+	switch r.Unit {
+	case unitMinute:
+		return 1440%r.Count == 0 && (loc == nil || loc == time.UTC || 15%r.Count == 0)
+	case unitDay:
+		return r.Count == 1 && r.location() == loc
+	}
+	return false
+}
+
+// epoch used to align multi-week resolutions. 1970-01-05 is a Monday.
+var firstMonday = time.Date(1970, 1, 5, 0, 0, 0, 0, time.UTC)
+
+// Start returns the start time (in UTC) of the bar containing t
+func (r Resolution) Start(t time.Time) time.Time {
+	if r.Unit == unitMinute {
+		return t.UTC().Truncate(time.Minute * time.Duration(r.Count))
+	}
+	loc := r.location()
+	t = t.In(loc)
+	// Count calendar days using the local date. Local days may be 23 or 25 hours long due to DST.
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	var start time.Time
+	switch r.Unit {
+	case unitDay:
+		days := floorDiv(date.Unix(), 86400)
+		days = floorDiv(days, int64(r.Count)) * int64(r.Count)
+		start = time.Date(1970, 1, 1+int(days), 0, 0, 0, 0, loc)
+	case unitWeek:
+		weeks := floorDiv(floorDiv(date.Unix()-firstMonday.Unix(), 86400), 7)
+		weeks = floorDiv(weeks, int64(r.Count)) * int64(r.Count)
+		start = time.Date(1970, 1, 5+int(weeks)*7, 0, 0, 0, 0, loc)
+	case unitMonth:
+		months := int64(t.Year())*12 + int64(t.Month()) - 1
+		months = floorDiv(months, int64(r.Count)) * int64(r.Count)
+		start = time.Date(int(months/12), time.Month(months%12+1), 1, 0, 0, 0, 0, loc)
+	}
+	return start.UTC()
+}
+
+// End returns the start time (in UTC) of the next bar after the bar starting at start
+func (r Resolution) End(start time.Time) time.Time {
+	// AddDate keeps the local time of day across DST transitions
+	start = start.In(r.location())
+	switch r.Unit {
+	case unitDay:
+		return start.AddDate(0, 0, r.Count).UTC()
+	case unitWeek:
+		return start.AddDate(0, 0, 7*r.Count).UTC()
+	case unitMonth:
+		return start.AddDate(0, r.Count, 0).UTC()
+	}
+	return start.Add(time.Minute * time.Duration(r.Count)).UTC()
+}
+
+// CanDeriveFrom checks if bars of this resolution can be built by combining bars of base
+func (r Resolution) CanDeriveFrom(base Resolution) bool {
+	if base.Minutes() > r.Minutes() || base.Unit == r.Unit && base.Count == r.Count {
+		return false
+	}
+	if r.Unit == base.Unit {
+		return r.Count%base.Count == 0 && (r.Unit == unitMinute || r.location() == base.location())
+	}
+	switch r.Unit {
+	case unitDay:
+		return base.Unit == unitMinute && base.alignsWithDays(r.location())
+	case unitWeek, unitMonth:
+		return base.alignsWithDays(r.location())
+	}
+	return false
+}
+
+// floorDiv divides rounding towards negative infinity
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// Storage backend types
+const storeTypeFile = "file"
+const storeTypeBolt = "bolt"
+
+// Store persists trades and bars of all symbols.
+// Trades are always in decending order and bars in ascending order.
+type Store interface {
+	// AppendTrades adds new trades (in decending order) after the last stored trade
+	AppendTrades(ticker string, trades []client.Trade) error
+	// LastTradeTime returns the time of the latest stored trade. Zero if there are no trades.
+	LastTradeTime(ticker string) (time.Time, error)
+	// TradesSince returns all trades on or after the given time in decending order
+	TradesSince(ticker string, since time.Time) ([]client.Trade, error)
+	// TradesBetween returns the trades within the time range (inclusive) in decending order
+	TradesBetween(ticker string, since, until time.Time) ([]client.Trade, error)
+	// QueryBars returns bars within the time range (Unix Epoch seconds, inclusive)
+	QueryBars(ticker, resolution string, from, to int64) ([]Bar, error)
+	// LastBars returns up to count latest bars on or before `to` (Unix Epoch seconds)
+	LastBars(ticker, resolution string, to int64, count int) ([]Bar, error)
+	// SaveBars replaces all existing bars on or after `from` (Unix Epoch seconds) with bars
+	SaveBars(ticker, resolution string, from int64, bars []Bar) error
+	// BarsMeta returns the configuration used to generate the stored bars
+	BarsMeta(ticker string) (barsMeta, error)
+	// SaveBarsMeta stores the configuration used to generate the bars
+	SaveBarsMeta(ticker string, meta barsMeta) error
+	// Annotations returns all curated chart events in ascending order of time
+	Annotations() ([]Annotation, error)
+	// SaveAnnotation adds or replaces (by ID) an annotation
+	SaveAnnotation(a Annotation) error
+	// DeleteAnnotation removes an annotation. Returns os.ErrNotExist if not found.
+	DeleteAnnotation(id string) error
+	Close() error
+}
+
+// StoreConfig describes the storage backend
+type StoreConfig struct {
+	// Type of backend. Supported types: "file" (default) and "bolt"
+	Type string `json:"type"`
+	// Root directory for "file" or database file for "bolt".
+	// Default: "./data" and "./data/halodex.db" respectively
+	Path string `json:"path"`
+}
+
+var store Store
+
+// Latest time that can be stored. Upper bound of trade queries without end.
+var maxTradeTime = time.Unix(0, math.MaxInt64).UTC()
+
+func openStore(c StoreConfig) (Store, error) {
+	switch strings.ToLower(c.Type) {
+	case "", storeTypeFile:
+		if c.Path == "" {
+			c.Path = dataRootDir
+		}
+		return newFileStore(c.Path), nil
+	case storeTypeBolt:
+		if c.Path == "" {
+			c.Path = dataRootDir + "/halodex.db"
+		}
+		return openBoltStore(c.Path)
+	}
+	return nil, fmt.Errorf("Unsupported store type: %s", c.Type)
+}
+
+// loadAllBars returns all stored bars of a resolution
+func loadAllBars(s Store, ticker, resolution string) ([]Bar, error) {
+	return s.QueryBars(ticker, resolution, math.MinInt64, math.MaxInt64)
+}
+
+// fileStore stores trades of each symbol in a trade log and bars of each resolution
+// in a JSON file within "<root>/<ticker>" directory.
+// Annotations of all symbols are stored in "<root>/annotations.json".
+// Bar and annotation files are kept in memory once read.
+type fileStore struct {
+	rootDir     string
+	mutex       sync.Mutex
+	logs        map[string]*tradeLog
+	bars        map[string][]Bar // filename : []Bar
+	annotations []Annotation     // nil until read
+}
+
+func newFileStore(rootDir string) *fileStore {
+	return &fileStore{
+		rootDir: rootDir,
+		logs:    map[string]*tradeLog{},
+		bars:    map[string][]Bar{},
+	}
+}
+
+func (s *fileStore) dir(ticker string) string {
+	return fmt.Sprintf("%s/%s", s.rootDir, strings.ToLower(ticker))
+}
+
+func (s *fileStore) barsFile(ticker, resolution string) string {
+	return fmt.Sprintf("%s/%s.json", s.dir(ticker), resolution)
+}
+
+// tradeLog opens the trade log of the ticker once
+func (s *fileStore) tradeLog(ticker string) (l *tradeLog, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ticker = strings.ToLower(ticker)
+	if l = s.logs[ticker]; l != nil {
+		return
+	}
+	if l, err = openTradeLog(s.dir(ticker)); err != nil {
+		return
+	}
+	s.logs[ticker] = l
+	return
+}
+
+func (s *fileStore) AppendTrades(ticker string, trades []client.Trade) error {
+	l, err := s.tradeLog(ticker)
+	if err != nil {
+		return err
+	}
+	return l.Append(trades)
+}
+
+func (s *fileStore) LastTradeTime(ticker string) (time.Time, error) {
+	l, err := s.tradeLog(ticker)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.LastTradeTime()
+}
+
+func (s *fileStore) TradesSince(ticker string, since time.Time) ([]client.Trade, error) {
+	l, err := s.tradeLog(ticker)
+	if err != nil {
+		return nil, err
+	}
+	return l.ReadSince(since)
+}
+
+func (s *fileStore) TradesBetween(ticker string, since, until time.Time) ([]client.Trade, error) {
+	l, err := s.tradeLog(ticker)
+	if err != nil {
+		return nil, err
+	}
+	return l.ReadBetween(since, until)
+}
+
+// QueryBars reads the whole resolution file (once) and returns the bars within the range
+func (s *fileStore) QueryBars(ticker, resolution string, from, to int64) (bars []Bar, err error) {
+	filename := s.barsFile(ticker, resolution)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	bars, exists := s.bars[filename]
+	if !exists {
+		if _, err = os.Stat(filename); os.IsNotExist(err) {
+			return nil, nil
+		}
+		log.Printf("Loading bars from storage: %s", filename)
+		if bars, err = readBarsFile(filename); err != nil {
+			return
+		}
+		s.bars[filename] = bars
+	}
+	return sliceBars(bars, from, to), nil
+}
+
+func (s *fileStore) LastBars(ticker, resolution string, to int64, count int) (bars []Bar, err error) {
+	if bars, err = s.QueryBars(ticker, resolution, math.MinInt64, to); err != nil {
+		return
+	}
+	return lastBars(bars, count), nil
+}
+
+func (s *fileStore) SaveBars(ticker, resolution string, from int64, bars []Bar) (err error) {
+	existing := []Bar{}
+	if from > math.MinInt64 {
+		if existing, err = s.QueryBars(ticker, resolution, math.MinInt64, from-1); err != nil {
+			return
+		}
+	}
+	if err = os.MkdirAll(s.dir(ticker), 0755); err != nil {
+		return
+	}
+	filename := s.barsFile(ticker, resolution)
+	// existing is a slice of the cached bars. Copy to avoid modifying them.
+	bars = append(append([]Bar{}, existing...), bars...)
+	if err = client.SaveJSONFile(filename, bars); err != nil {
+		return
+	}
+	s.mutex.Lock()
+	s.bars[filename] = bars
+	s.mutex.Unlock()
+	return
+}
+
+func (s *fileStore) BarsMeta(ticker string) (meta barsMeta, err error) {
+	txt, err := client.ReadFile(s.dir(ticker) + "/bars.meta.json")
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(txt), &meta)
+	return
+}
+
+func (s *fileStore) SaveBarsMeta(ticker string, meta barsMeta) error {
+	return client.SaveJSONFile(s.dir(ticker)+"/bars.meta.json", meta)
+}
+
+func (s *fileStore) annotationsFile() string {
+	return s.rootDir + "/annotations.json"
+}
+
+// loadAnnotations reads the annotations file once. Expects mutex to be locked.
+func (s *fileStore) loadAnnotations() (err error) {
+	if s.annotations != nil {
+		return
+	}
+	annotations := []Annotation{}
+	if _, err = os.Stat(s.annotationsFile()); err == nil {
+		txt, err := client.ReadFile(s.annotationsFile())
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal([]byte(txt), &annotations); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return
+	}
+	s.annotations = annotations
+	return nil
+}
+
+// Annotations returns a copy of all annotations
+func (s *fileStore) Annotations() ([]Annotation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.loadAnnotations(); err != nil {
+		return nil, err
+	}
+	return append([]Annotation{}, s.annotations...), nil
+}
+
+func (s *fileStore) SaveAnnotation(a Annotation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.loadAnnotations(); err != nil {
+		return err
+	}
+	annotations := []Annotation{}
+	for _, existing := range s.annotations {
+		if existing.ID != a.ID {
+			annotations = append(annotations, existing)
+		}
+	}
+	annotations = append(annotations, a)
+	sort.SliceStable(annotations, func(i, j int) bool { return annotations[i].Time.Before(annotations[j].Time) })
+	return s.saveAnnotations(annotations)
+}
+
+func (s *fileStore) DeleteAnnotation(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.loadAnnotations(); err != nil {
+		return err
+	}
+	annotations := []Annotation{}
+	for _, existing := range s.annotations {
+		if existing.ID != id {
+			annotations = append(annotations, existing)
+		}
+	}
+	if len(annotations) == len(s.annotations) {
+		return os.ErrNotExist
+	}
+	return s.saveAnnotations(annotations)
+}
+
+// saveAnnotations writes the annotations file. Expects mutex to be locked.
+func (s *fileStore) saveAnnotations(annotations []Annotation) (err error) {
+	if err = os.MkdirAll(s.rootDir, 0755); err != nil {
+		return
+	}
+	if err = client.SaveJSONFile(s.annotationsFile(), annotations); err != nil {
+		return
+	}
+	s.annotations = annotations
+	return
+}
+
+func (s *fileStore) Close() error { return nil }
+
+// lastBars returns up to count bars from the end
+func lastBars(bars []Bar, count int) []Bar {
+	if count < len(bars) {
+		return bars[len(bars)-count:]
+	}
+	return bars
+}
+
+// sliceBars returns the bars within the time range (inclusive).
+// Expects bars to be in ascending order.
+func sliceBars(bars []Bar, from, to int64) []Bar {
+	start := sort.Search(len(bars), func(i int) bool { return bars[i].UnixTime >= from })
+	end := sort.Search(len(bars), func(i int) bool { return bars[i].UnixTime > to })
+	if start >= end {
+		return nil
+	}
+	return bars[start:end]
+}
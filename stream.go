@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// Stream event types
+const streamEventBar = "bar"            // update of the in-progress bar
+const streamEventBarClose = "bar_close" // bar is complete and will not change anymore
+const streamEventTrade = "trade"        // new trade retrieved by sync
+const streamEventError = "error"        // invalid request by the subscriber
+
+// StreamEvent describes a real-time update published to the subscribers after each sync
+type StreamEvent struct {
+	Type       string `json:"type"`
+	Symbol     string `json:"symbol"`
+	Resolution string `json:"resolution,omitempty"`
+	Bar        *Bar   `json:"bar,omitempty"`
+	// only when Type == trade
+	Trade *client.Trade `json:"trade,omitempty"`
+	// only when Type == error
+	Message string `json:"errmsg,omitempty"`
+}
+
+// streamHub keeps track of subscribers and distributes events by topic (ticker + resolution)
+type streamHub struct {
+	mutex       sync.RWMutex
+	subscribers map[*streamSubscriber]bool
+}
+
+// streamSubscriber receives events of the subscribed topics through the Events channel
+type streamSubscriber struct {
+	mutex  sync.RWMutex
+	topics map[string]bool
+	Events chan StreamEvent
+}
+
+var hub = newStreamHub()
+
+func newStreamHub() *streamHub {
+	return &streamHub{subscribers: map[*streamSubscriber]bool{}}
+}
+
+func streamTopic(ticker, resolution string) string {
+	return fmt.Sprintf("%s|%s", strings.ToLower(ticker), resolution)
+}
+
+// Register adds a new subscriber without any topics
+func (h *streamHub) Register() *streamSubscriber {
+	s := &streamSubscriber{
+		topics: map[string]bool{},
+		Events: make(chan StreamEvent, 64),
+	}
+	h.mutex.Lock()
+	h.subscribers[s] = true
+	h.mutex.Unlock()
+	return s
+}
+
+// Unregister removes the subscriber and closes it's Events channel
+func (h *streamHub) Unregister(s *streamSubscriber) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !h.subscribers[s] {
+		return
+	}
+	delete(h.subscribers, s)
+	close(s.Events)
+}
+
+// Publish sends the event to all subscribers of the event's topic.
+// Events without resolution (eg: trades) are sent to all subscribers of the symbol.
+// Slow subscribers will miss events rather than blocking the sync process.
+func (h *streamHub) Publish(event StreamEvent) {
+	topic := streamTopic(event.Symbol, event.Resolution)
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for s := range h.subscribers {
+		if event.Resolution == "" && s.IsSubscribedToSymbol(event.Symbol) ||
+			event.Resolution != "" && s.IsSubscribed(topic) {
+			s.Send(event)
+		}
+	}
+}
+
+// Send queues an event for this subscriber only. Drops the event if the buffer is full.
+func (s *streamSubscriber) Send(event StreamEvent) {
+	select {
+	case s.Events <- event:
+	default:
+		log.Println("[stream] subscriber buffer full. Event dropped:", event.Type)
+	}
+}
+
+// Subscribe adds the topic to the subscriber
+func (s *streamSubscriber) Subscribe(ticker, resolution string) {
+	s.mutex.Lock()
+	s.topics[streamTopic(ticker, resolution)] = true
+	s.mutex.Unlock()
+}
+
+// Unsubscribe removes the topic from the subscriber
+func (s *streamSubscriber) Unsubscribe(ticker, resolution string) {
+	s.mutex.Lock()
+	delete(s.topics, streamTopic(ticker, resolution))
+	s.mutex.Unlock()
+}
+
+// IsSubscribed checks if subscriber is subscribed to the topic
+func (s *streamSubscriber) IsSubscribed(topic string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.topics[topic]
+}
+
+// IsSubscribedToSymbol checks if subscriber is subscribed to any resolution of the symbol
+func (s *streamSubscriber) IsSubscribedToSymbol(ticker string) bool {
+	prefix := streamTopic(ticker, "")
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for topic := range s.topics {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// publishTrades publishes new trades in chronological order.
+// Expects trades to be in decending order.
+func publishTrades(ticker string, trades []client.Trade) {
+	for i := len(trades) - 1; i >= 0; i-- {
+		trade := trades[i]
+		hub.Publish(StreamEvent{
+			Type:   streamEventTrade,
+			Symbol: ticker,
+			Trade:  &trade,
+		})
+	}
+}
+
+// publishBarUpdates compares the previous and newly generated bars of a resolution
+// and publishes the changes since the previous last bar.
+// All but the last of the changed bars are published as closed bars.
+func publishBarUpdates(ticker, resolution string, prevBars, bars []Bar) {
+	if len(bars) == 0 {
+		return
+	}
+	i := len(bars) - 1
+	if len(prevBars) > 0 {
+		lastTime := prevBars[len(prevBars)-1].UnixTime
+		for i > 0 && bars[i-1].UnixTime >= lastTime {
+			i--
+		}
+	}
+	for ; i < len(bars); i++ {
+		bar := bars[i]
+		eventType := streamEventBarClose
+		if i == len(bars)-1 {
+			eventType = streamEventBar
+		}
+		hub.Publish(StreamEvent{
+			Type:       eventType,
+			Symbol:     ticker,
+			Resolution: resolution,
+			Bar:        &bar,
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const streamPingInterval = 30 * time.Second
+const streamWriteTimeout = 10 * time.Second
+
+// Stream request actions
+const streamActionSubscribe = "subscribe"
+const streamActionUnsubscribe = "unsubscribe"
+
+// StreamRequest describes a message sent by the WebSocket client
+// Example: {"action": "subscribe", "symbol": "HALO/ETH", "resolution": "60"}
+type StreamRequest struct {
+	Action     string `json:"action"`
+	Symbol     string `json:"symbol"`
+	Resolution string `json:"resolution"`
+}
+
+var upgrader = websocket.Upgrader{
+	// Same as allowCORS, any origin is allowed
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamHandler upgrades the connection to WebSocket and streams bar updates
+// of the subscribed symbol and resolution pairs.
+// After subscription, the latest bar is sent immediately followed by
+// "bar" (in-progress bar update) and "bar_close" events after every sync.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already responded with an error
+		log.Println("[stream] upgrade failed", err)
+		return
+	}
+	subscriber := hub.Register()
+	go streamWriter(conn, subscriber)
+	defer hub.Unregister(subscriber)
+
+	for {
+		req := StreamRequest{}
+		if err := conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("[stream] read failed", err)
+			}
+			return
+		}
+		symbol, found := findSymbol(req.Symbol)
+		if !found || !isSupportedResolution(req.Resolution) {
+			sendStreamError(subscriber, "Invalid symbol or resolution")
+			continue
+		}
+		switch req.Action {
+		case streamActionSubscribe:
+			subscriber.Subscribe(symbol.Ticker, req.Resolution)
+			bars, err := getResolution(strings.ToLower(symbol.Ticker), req.Resolution)
+			if err == nil && len(bars) > 0 {
+				bar := bars[len(bars)-1]
+				subscriber.Send(StreamEvent{
+					Type:       streamEventBar,
+					Symbol:     symbol.Ticker,
+					Resolution: req.Resolution,
+					Bar:        &bar,
+				})
+			}
+		case streamActionUnsubscribe:
+			subscriber.Unsubscribe(symbol.Ticker, req.Resolution)
+		default:
+			sendStreamError(subscriber, "Invalid action")
+		}
+	}
+}
+
+// streamWriter writes all events received by the subscriber to the connection.
+// Exits when the subscriber is unregistered or the connection fails.
+func streamWriter(conn *websocket.Conn, subscriber *streamSubscriber) {
+	ping := time.NewTicker(streamPingInterval)
+	defer func() {
+		ping.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case event, ok := <-subscriber.Events:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				log.Println("[stream] write failed", err)
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func sendStreamError(subscriber *streamSubscriber, msg string) {
+	subscriber.Send(StreamEvent{Type: streamEventError, Message: msg})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	// embed the timezone database for systems without one
+	_ "time/tzdata"
+)
+
+const defaultSession = "24x7"
+const defaultTimeZone = "Etc/UTC"
+
+// Gap policies: how intervals without trades are displayed
+const (
+	// Bars without trades are skipped
+	gapPolicyLeave = "leave"
+	// Stored bars include flat bars with the previous closing price and zero volume
+	gapPolicyFill = "fill"
+	// Charting library generates the empty bars (has_empty_bars)
+	gapPolicyLibrary = "library"
+)
+
+// Session format: "HHMM-HHMM" or multiple sessions separated by comma.
+// Optionally followed by the days of the week. Eg: "0930-1600:23456"
+var sessionRegex = regexp.MustCompile(`^(24x7|\d{4}-\d{4}(,\d{4}-\d{4})*(:[1-7]+)?(\|\d{4}-\d{4}(,\d{4}-\d{4})*(:[1-7]+)?)*)$`)
+
+// SymbolConfig overrides symbol settings for a single symbol or all symbols of a base token.
+// Symbol overrides take precedence over base token overrides.
+type SymbolConfig struct {
+	// Symbol name or ticker. Eg: "HALO/ETH" or "Other:HALO/ETH"
+	Symbol string `json:"symbol"`
+	// Base token ticker. Eg: "ETH" for all */ETH pairs
+	Base string `json:"base"`
+	// Trading session. Eg: "24x7" or "0900-1700"
+	Session string `json:"session"`
+	// Timezone in "olsondb" format. Eg: "America/New_York".
+	// Daily, weekly and monthly bars start at midnight of this timezone.
+	TimeZone string `json:"timezone"`
+	// Gap policy: "leave" (default), "fill" or "library"
+	GapPolicy string `json:"gappolicy"`
+	// Resolutions filled by the "fill" gap policy. Default: all resolutions
+	FillResolutions []string `json:"fillresolutions"`
+
+	location *time.Location
+}
+
+// setupSymbolConfigs validates the symbol overrides and loads the timezones
+func setupSymbolConfigs() error {
+	for i := range conf.Symbols {
+		c := &conf.Symbols[i]
+		if c.Symbol == "" && c.Base == "" {
+			return fmt.Errorf("symbols[%d]: symbol or base is required", i)
+		}
+		if c.Session != "" && !sessionRegex.MatchString(c.Session) {
+			return fmt.Errorf("symbols[%d]: invalid session: %s", i, c.Session)
+		}
+		c.GapPolicy = strings.ToLower(c.GapPolicy)
+		switch c.GapPolicy {
+		case "", gapPolicyLeave, gapPolicyFill, gapPolicyLibrary:
+		default:
+			return fmt.Errorf("symbols[%d]: invalid gap policy: %s", i, c.GapPolicy)
+		}
+		for _, res := range c.FillResolutions {
+			if _, err := parseResolution(res); err != nil {
+				return fmt.Errorf("symbols[%d]: invalid fill resolution: %s", i, res)
+			}
+		}
+		if c.TimeZone == "" {
+			continue
+		}
+		loc, err := time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return fmt.Errorf("symbols[%d]: invalid timezone: %s", i, c.TimeZone)
+		}
+		c.location = loc
+	}
+	return nil
+}
+
+// symbolConfigFor finds the overrides for the symbol.
+// Symbol specific settings take precedence over the ones of the base token.
+func symbolConfigFor(s Symbol) (result SymbolConfig) {
+	var base *SymbolConfig
+	for i := range conf.Symbols {
+		c := &conf.Symbols[i]
+		if c.Symbol != "" && (strings.EqualFold(c.Symbol, s.Ticker) || strings.EqualFold(c.Symbol, s.Name)) {
+			result = *c
+			break
+		}
+		if base == nil && c.Symbol == "" && strings.EqualFold(c.Base, s.BaseTicker) {
+			base = c
+		}
+	}
+	if base == nil {
+		return
+	}
+	// fill in the settings not set for the symbol using the base token settings
+	if result.Session == "" {
+		result.Session = base.Session
+	}
+	if result.location == nil {
+		result.TimeZone = base.TimeZone
+		result.location = base.location
+	}
+	if result.GapPolicy == "" {
+		result.GapPolicy = base.GapPolicy
+		result.FillResolutions = base.FillResolutions
+	}
+	return
+}
+
+// applySymbolConfig sets the session, timezone and gap policy of the symbol from the config
+func (s *Symbol) applySymbolConfig() {
+	c := symbolConfigFor(*s)
+	s.gapPolicy = gapPolicyLeave
+	if c.GapPolicy != "" {
+		s.gapPolicy = c.GapPolicy
+		s.fillResolutions = c.FillResolutions
+	}
+	s.HasEmptyBars = s.gapPolicy == gapPolicyLibrary
+	if c.Session != "" {
+		s.Session = c.Session
+	}
+	if c.location != nil {
+		s.TimeZone = c.TimeZone
+		s.location = c.location
+	}
+}
+
+// Location returns the timezone used to align daily, weekly and monthly bars
+func (s Symbol) Location() *time.Location {
+	if s.location == nil {
+		return time.UTC
+	}
+	return s.location
+}
+
+// FillsGaps checks if the stored bars of the resolution include bars without trades
+func (s Symbol) FillsGaps(resolution string) bool {
+	if s.gapPolicy != gapPolicyFill {
+		return false
+	}
+	return len(s.fillResolutions) == 0 || containsString(s.fillResolutions, resolution)
+}
+
+// gapsKey identifies the gap policy applied to the symbol's bars.
+// Bars are regenerated whenever it changes.
+func (s Symbol) gapsKey() string {
+	if s.gapPolicy != gapPolicyFill {
+		return ""
+	}
+	return gapPolicyFill + ":" + strings.Join(s.fillResolutions, ",")
+}
+
+// symbolLocation returns the timezone of the symbol by ticker. Defaults to UTC.
+func symbolLocation(ticker string) *time.Location {
+	symbol, _ := symbols.Get(ticker)
+	return symbol.Location()
+}
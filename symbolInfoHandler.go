@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SymbolInfo describes a group of symbols in the column-oriented format as described here:
+// https://github.com/tradingview/charting_library/wiki/UDF#symbol-group-info
+// Each attribute is an array where the n-th item belongs to the n-th symbol.
+type SymbolInfo struct {
+	Symbol               []string   `json:"symbol"`
+	Ticker               []string   `json:"ticker"`
+	Name                 []string   `json:"name"`
+	Description          []string   `json:"description"`
+	Type                 []string   `json:"type"`
+	ExchangeListed       []string   `json:"exchange-listed"`
+	ExchangeTraded       []string   `json:"exchange-traded"`
+	TimeZone             []string   `json:"timezone"`
+	SessionRegular       []string   `json:"session-regular"`
+	MinMovement          []float64  `json:"minmovement"`
+	MinMovement2         []float64  `json:"minmovement2"`
+	PriceScale           []int64    `json:"pricescale"`
+	Fractional           []bool     `json:"fractional"`
+	HasIntraDay          []bool     `json:"has-intraday"`
+	HasDWM               []bool     `json:"has-dwm"`
+	HasWeeklyAndMonthly  []bool     `json:"has-weekly-and-monthly"`
+	HasEmptyBars         []bool     `json:"has-empty-bars"`
+	HasNoVolume          []bool     `json:"has-no-volume"`
+	VolumePrecision      []int      `json:"volume-precision"`
+	DataStatus           []string   `json:"data-status"`
+	SupportedResolutions [][]string `json:"supported-resolutions"`
+	IntraDayMultipliers  [][]string `json:"intraday-multipliers"`
+}
+
+// newSymbolInfo converts a list of symbols to the column-oriented group format
+func newSymbolInfo(list []Symbol) (info SymbolInfo) {
+	for _, s := range list {
+		info.Symbol = append(info.Symbol, s.Name)
+		info.Ticker = append(info.Ticker, s.Ticker)
+		info.Name = append(info.Name, s.Name)
+		info.Description = append(info.Description, s.Description)
+		info.Type = append(info.Type, s.Type)
+		info.ExchangeListed = append(info.ExchangeListed, s.ListedExchange)
+		info.ExchangeTraded = append(info.ExchangeTraded, s.Exchange)
+		info.TimeZone = append(info.TimeZone, s.TimeZone)
+		info.SessionRegular = append(info.SessionRegular, s.Session)
+		info.MinMovement = append(info.MinMovement, s.MinMov)
+		info.MinMovement2 = append(info.MinMovement2, s.MinMove2)
+		info.PriceScale = append(info.PriceScale, s.PriceScale)
+		info.Fractional = append(info.Fractional, s.Fractional)
+		info.HasIntraDay = append(info.HasIntraDay, s.HasIntraDay)
+		info.HasDWM = append(info.HasDWM, s.HasDaily)
+		info.HasWeeklyAndMonthly = append(info.HasWeeklyAndMonthly, s.HasWeeklyAndMonthly)
+		info.HasEmptyBars = append(info.HasEmptyBars, s.HasEmptyBars)
+		info.HasNoVolume = append(info.HasNoVolume, s.HasNoVolume)
+		info.VolumePrecision = append(info.VolumePrecision, s.VolumePrecision)
+		info.DataStatus = append(info.DataStatus, s.DataStatus)
+		info.SupportedResolutions = append(info.SupportedResolutions, s.SupportedResolutions)
+		info.IntraDayMultipliers = append(info.IntraDayMultipliers, s.IntraDayMultipliers)
+	}
+	return
+}
+
+// symbolInfoHandler responds with information of all supported symbols in a single request.
+// Only available if "supports_group_request" is enabled in the chart configuration.
+// GET Params:
+// @group (optional) base token ticker. Example: "ETH" for all */ETH pairs
+func symbolInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if !conf.ChartConfig.GroupRequest {
+		respondNotImplemented(w, r)
+		return
+	}
+	params := newRequestParams(r)
+	group := strings.ToUpper(params.String("group", false))
+	list := []Symbol{}
+	for _, s := range symbols.List() {
+		if group != "" && s.BaseTicker != group {
+			continue
+		}
+		list = append(list, s)
+	}
+	if len(list) == 0 {
+		respondUDFError(w, "unknown_group", err404)
+		return
+	}
+	respondJSON(w, newSymbolInfo(list), ok200)
+}
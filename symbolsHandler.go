@@ -1,12 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
-
-	"github.com/alien45/halo-info-bot/client"
+	"time"
 )
 
 // Symbol describes a tradable entity for which trading chart can be generated.
@@ -36,9 +36,9 @@ type Symbol struct {
 	// Both Exchange and ListedExchange fields are expected to have a
 	// short name of the exchange where this symbol is traded.
 	// The name will be displayed in the chart legend for this symbol.
-	// [=] use "HaloDEX"
+	// [=] use name of the trade source. Eg: "HaloDEX"
 	Exchange string `json:"exchange"`
-	// [=] use "HaloDEX"
+	// [=] use name of the trade source. Eg: "HaloDEX"
 	ListedExchange string `json:"listed_exchange"`
 	// Timezone of the exchange for this symbol in "olsondb" format.
 	// [=] use "Etc/UTC"
@@ -81,7 +81,7 @@ type Symbol struct {
 	// Array of resolutions (in minutes) supported directly by the data feed.
 	// The default of [] means that the data feed supports aggregating by any number of minutes.
 	// https://github.com/tradingview/charting_library/wiki/Symbology#intraday_multipliers
-	// [~] leave empty for now
+	// [=] use the stored intraday resolutions. Other multiples of them are derived on request.
 	IntraDayMultipliers []string `json:"intraday_multipliers"`
 	// Boolean value showing whether the symbol includes seconds in the historical data.
 	// [~] ignore
@@ -94,10 +94,10 @@ type Symbol struct {
 	// [-] ignore
 	SecondsMultipliers []string `json:"seconds_multipliers"`
 	// Whether data feed has its own daily resolution bars or not.
-	// [=] use false. TODO: generate daily bar data.
+	// [=] use true if daily bars are stored or can be derived from a stored resolution.
 	HasDaily bool `json:"has_daily"`
 	// Whether data feed has its own weekly and monthly resolution bars or not.
-	// [=] use false.
+	// [=] use true if weekly and monthly bars are stored or can be derived.
 	HasWeeklyAndMonthly bool `json:"has_weekly_and_monthly"`
 	// whether the library should generate empty bars in the session
 	// When there is no data from the data feed for this particular time.
@@ -120,7 +120,7 @@ type Symbol struct {
 	VolumePrecision int `json:"volume_precision"`
 	// The status code of a series with this symbol. The status is shown in the upper right corner of a chart.
 	// Supported statuses: streaming, endofday, pulsed, delayed_streaming
-	// [=] use "pulsed" only
+	// [=] use "streaming". Real-time bar updates are available through "/stream"
 	DataStatus string `json:"data_status"`
 	// Whether this symbol is an expired futures contract or not.
 	// [-] ignore
@@ -135,80 +135,129 @@ type Symbol struct {
 	// [-] ignore ???
 	CurrencyCode string `json:"currency_code"`
 
-	// For HaloDEX sync
+	// For trade source sync
 	// Token smart contract address
 	Address string
 	// Paired base token smart contract address
 	BaseAddress string
+	// Paired base token ticker. Used to group symbols by base token.
+	BaseTicker string
+
+	// timezone of TimeZone. Used to align daily, weekly and monthly bars.
+	location *time.Location
+	// See SymbolConfig
+	gapPolicy       string
+	fillResolutions []string
+	// Legs of synthetic pairs. See SyntheticPair
+	legs []SyntheticLeg
 }
 
 // instantiate a Symbol struct with default values
-func newSymbol(name, ticker, description, address, baseAddress string) (s Symbol) {
+func newSymbol(exchange, name, ticker, description, address, baseAddress, baseTicker string) (s Symbol) {
 	s.Name = name
 	s.Ticker = ticker
 	s.Description = description
 	s.Type = "bitcoin" // use token/coin
-	s.Session = "24x7"
-	s.Exchange = "HaloDEX"
-	s.ListedExchange = "HaloDEX"
-	s.TimeZone = "Etc/UTC"
+	s.Session = defaultSession
+	s.Exchange = exchange
+	s.ListedExchange = exchange
+	s.TimeZone = defaultTimeZone
 	s.MinMov = 1
 	s.PriceScale = 1e8
 	s.HasIntraDay = true // [?]
 	s.IntraDayMultipliers = []string{}
-	for i := 0; i < len(resolutionMins); i++ {
-		s.IntraDayMultipliers = append(s.IntraDayMultipliers, fmt.Sprint(resolutionMins[i]))
+	for _, r := range resolutionSpecs {
+		if r.IsIntraDay() {
+			s.IntraDayMultipliers = append(s.IntraDayMultipliers, fmt.Sprint(r.Count))
+		}
 	}
 	s.SupportedResolutions = resolutions
-	s.HasDaily = false // [?]
-	s.HasEmptyBars = false
+	s.BaseTicker = baseTicker
+	s.applySymbolConfig()
+	loc := s.Location()
+	s.HasDaily = isAvailableResolution("1D", loc)
+	s.HasWeeklyAndMonthly = isAvailableResolution("1W", loc) && isAvailableResolution("1M", loc)
 	s.ForceSessionRebuild = true
-	s.DataStatus = "pulsed"
+	s.DataStatus = "streaming"
 	s.HasNoVolume = false
-	// For HaloDEX sync purposes
+	// For trade source sync purposes
 	s.Address = address
 	s.BaseAddress = baseAddress
 	return
 }
 
+// market returns the market of the symbol's trade source
+func (s Symbol) market() Market {
+	return Market{
+		QuoteTicker:  strings.Split(s.Name, "/")[0],
+		QuoteName:    s.Description,
+		QuoteAddress: s.Address,
+		BaseTicker:   s.BaseTicker,
+		BaseAddress:  s.BaseAddress,
+	}
+}
+
+// isTraded checks if the bars of the symbol are generated from it's trades.
+// Bars of synthetic and reference price symbols are calculated on request.
+func (s Symbol) isTraded() bool {
+	return s.Type != symbolTypeSynthetic && s.Type != symbolTypeReference
+}
+
+// updateSymbols retrieves markets of all trade sources.
+// Symbols of the first source use the pair name as ticker. Eg: "HALO/ETH".
+// Tickers of other sources are prefixed with the source name to keep them unique. Eg: "Other:HALO/ETH".
+// Reference prices are prefixed with "Reference". Eg: "Reference:ETH/USD".
+// Synthetic pairs are added last, prefixed with "Synthetic". Eg: "Synthetic:HALO/USDT".
 func updateSymbols() {
 	log.Println("Updaing symbols")
-	tokens, err := dex.GetTokens()
-	symbols = []Symbol{}
-	panicIf(err, "Failed to retrieve tokens")
-	log.Println("Tokens received: ", len(tokens))
-	baseTokens := []client.Token{}
-	quoteTokens := []client.Token{}
-	for ticker, token := range tokens {
-		ticker = strings.ToUpper(ticker)
-		if token.Type == "BASE" {
-			baseTokens = append(baseTokens, token)
+	list := []Symbol{}
+	for i, exchange := range sourceNames {
+		source, _ := getSource(exchange)
+		markets, err := source.ListMarkets()
+		if err != nil {
+			log.Println("Failed to retrieve markets", exchange, err)
 			continue
 		}
-		quoteTokens = append(quoteTokens, token)
-
-	}
-	for _, baseT := range baseTokens {
-		for _, quoteT := range quoteTokens {
-			symbolStr := quoteT.Ticker + "/" + baseT.Ticker
+		log.Println("Markets received: ", exchange, len(markets))
+		for _, m := range markets {
+			symbolStr := m.QuoteTicker + "/" + m.BaseTicker
+			ticker := symbolStr
+			if i > 0 {
+				ticker = exchange + ":" + symbolStr
+			}
 			s := newSymbol(
+				exchange,
 				symbolStr,
-				symbolStr,
-				quoteT.Name,
-				quoteT.HaloChainAddress, baseT.HaloChainAddress)
-			symbols = append(symbols, s)
-			log.Println("Adding pair: ", symbolStr, quoteT.Name,
-				quoteT.HaloChainAddress, baseT.HaloChainAddress)
+				ticker,
+				m.QuoteName,
+				m.QuoteAddress, m.BaseAddress,
+				m.BaseTicker)
+			list = append(list, s)
+			log.Println("Adding pair: ", ticker, m.QuoteName,
+				m.QuoteAddress, m.BaseAddress)
 		}
 	}
-
+	if len(list) == 0 {
+		panicIf(errors.New("No symbols available"), "Failed to retrieve markets")
+	}
+	list = append(list, newReferenceSymbols()...)
+	symbols.Set(list)
+	// Legs of synthetic pairs are found among the symbols of the trade sources and reference prices
+	pairs := append(conf.SyntheticPairs[:len(conf.SyntheticPairs):len(conf.SyntheticPairs)], fiatSyntheticPairs(list)...)
+	if synthetic := newSyntheticSymbols(pairs); len(synthetic) > 0 {
+		symbols.Set(append(list[:len(list):len(list)], synthetic...))
+	}
 }
 
-// Only search by name or ticker for now
+// Search by name or ticker. Optionally filter by type and exchange.
 func seachSymbols(tickerOrName, typeStr, exchange string) (result []Symbol, count int) {
 	tickerOrName = strings.ToLower(tickerOrName)
 	// Search by ticker name or symbol
-	for _, symbol := range symbols {
+	for _, symbol := range symbols.List() {
+		if typeStr != "" && !strings.EqualFold(symbol.Type, typeStr) ||
+			exchange != "" && !strings.EqualFold(symbol.Exchange, exchange) {
+			continue
+		}
 		if strings.Contains(strings.ToLower(symbol.Name), tickerOrName) ||
 			strings.Contains(strings.ToLower(symbol.Ticker), tickerOrName) {
 			result = append(result, symbol)
@@ -218,13 +267,19 @@ func seachSymbols(tickerOrName, typeStr, exchange string) (result []Symbol, coun
 	return
 }
 
+// findSymbol finds symbol by name or ticker. Name can be prefixed by exchange. Eg: "HaloDEX:HALO/ETH".
+// Without exchange, the first matching symbol is returned.
 func findSymbol(symbolStr string) (result Symbol, found bool) {
+	exchange := ""
 	ar := strings.Split(symbolStr, ":")
 	if len(ar) > 1 && strings.TrimSpace(ar[1]) != "" {
-		// Ignore exchange
+		exchange = strings.TrimSpace(ar[0])
 		symbolStr = ar[1]
 	}
-	for _, symbol := range symbols {
+	for _, symbol := range symbols.List() {
+		if exchange != "" && strings.ToLower(symbol.Exchange) != strings.ToLower(exchange) {
+			continue
+		}
 		if strings.ToLower(symbol.Name) == strings.ToLower(symbolStr) {
 			result = symbol
 			found = true
@@ -237,15 +292,9 @@ func findSymbol(symbolStr string) (result Symbol, found bool) {
 // symbolsHandler returns a specific symbol by Ticker or Name or
 // Exchange and ticker as in the following format of "Exchange:Ticker"
 func symbolsHandler(w http.ResponseWriter, r *http.Request) {
-	symbolStr := r.URL.Query().Get("symbol")
-	if symbolStr == "" {
-		respondError(w, "", err400)
-		return
-	}
-
-	result, found := findSymbol(symbolStr)
-	if !found {
-		respondError(w, "", err404)
+	params := newRequestParams(r)
+	result := params.Symbol("symbol")
+	if params.RespondIfInvalid(w) {
 		return
 	}
 	respondJSON(w, result, ok200)
@@ -269,13 +318,19 @@ type SearchResultSymbol struct {
 // @exchange
 // @limit
 func searchHandler(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("query")
-
-	result, count := seachSymbols(query, "", "")
-	if count == 0 {
-		respondError(w, "", err404)
+	params := newRequestParams(r)
+	query := params.String("query", false)
+	typeStr := params.String("type", false)
+	exchange := params.String("exchange", false)
+	limit := params.Int("limit", false)
+	if params.RespondIfInvalid(w) {
 		return
 	}
+
+	result, count := seachSymbols(query, typeStr, exchange)
+	if limit > 0 && count > limit {
+		result = result[:limit]
+	}
 	srsResult := []SearchResultSymbol{}
 	for i := 0; i < len(result); i++ {
 		s := result[i]
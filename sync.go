@@ -1,86 +1,70 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/alien45/halo-info-bot/client"
 )
 
-// Synchronizes trade history from HaloDEX to local directory
+// Synchronizes trade history from the symbol's trade source to the store
 /*
 	 Steps:
-	 1. Load existing history file if exists.
-	 2. Get the last trade's timestamp if file exists.
+	 1. Get the last stored trade's timestamp if any.
 		 Otherwise use 0 to retrieve trades since inception.
-	 3.	Add retrieved trades to loaded file and save
-	 4. Re-generate bars
-	 5. Update in-memory cached bars
+	 2.	Append retrieved trades to the store
+	 3. Update bars
+	 4. Update in-memory cached bars
 */
-func sync(ticker string, generateBars bool) (err error) {
+func syncTicker(ticker string, generateBars bool) (err error) {
 	ticker = strings.ToLower(ticker)
 	log.Println("Syncing trades: ", ticker)
-	dir := fmt.Sprintf("%s/%s", dataRootDir, ticker)
-	tradesFile := dir + "/trades.json"
-
-	txt, err := client.ReadFile(tradesFile)
-	if err != nil {
-		if _, err = os.Stat(tradesFile); !os.IsNotExist(err) {
-			log.Println(err)
-			return
-		}
-		// makes sure file path exists when saving file
-		err = os.MkdirAll(dir, 0755)
-		if err != nil {
-			log.Println("Failed to create directory", dir)
-			return
-		}
-		txt = "[]"
+	syncStart := clock.Now()
+	if !syncing.TryLock(ticker) {
+		return errors.New("Sync already in progress")
 	}
-	trades := []client.Trade{}
-	err = json.Unmarshal([]byte(txt), &trades)
+	defer syncing.Unlock(ticker)
+	symbol, found := symbols.Get(ticker)
+	if !found {
+		return errors.New("Symbol not found")
+	}
+
+	startTime, err := store.LastTradeTime(ticker)
 	if err != nil {
 		log.Println("Sync failed", err)
 		return
 	}
-	log.Printf("Loaded existing trades: %d", len(trades))
-	symbol := Symbol{}
-	for i := 0; i < len(symbols); i++ {
-		if strings.ToLower(symbols[i].Ticker) == strings.ToLower(ticker) {
-			symbol = symbols[i]
-		}
-	}
-	if symbol.Ticker == "" {
-		return errors.New("Symbol not found")
-	}
-
-	startTime := time.Time{}
-	if len(trades) > 0 {
+	if !startTime.IsZero() {
 		// add a nanosecond to make sure last item is not retrieved again
-		startTime = trades[0].Time.UTC().Add(time.Nanosecond)
+		startTime = startTime.UTC().Add(time.Nanosecond)
 	}
 
-	newTrades, err := dex.GetTradesByTime(symbol.Address, symbol.BaseAddress, startTime)
+	source, found := getSource(symbol.Exchange)
+	if !found {
+		return errors.New("Trade source not found: " + symbol.Exchange)
+	}
+	newTrades, err := source.FetchTradesSince(symbol.market(), startTime)
 	if err != nil {
 		log.Println("Failed to retrieve trades", err)
 		return
 	}
-	trades = append(newTrades, trades...)
-	err = client.SaveJSONFileLarge(tradesFile, trades)
-	log.Println("File: ", tradesFile)
+	err = store.AppendTrades(ticker, newTrades)
 	if err != nil {
-		log.Println("File save failed", tradesFile, err)
+		log.Println("Failed to save trades", ticker, err)
 		return
 	}
 
-	log.Printf("Sync complete. Ticker: %s, Total Trades: %d, New: %d", ticker, len(trades), len(newTrades))
+	// publish trades in the units after corporate actions, same as the bars
+	published := append([]client.Trade{}, newTrades...)
+	applyCorporateActions(symbol, published)
+	publishTrades(ticker, published)
+	whaleMarks.Add(symbol, published)
+	log.Printf("Sync complete. Ticker: %s, New: %d, Duration: %s",
+		ticker, len(newTrades), clock.Now().Sub(syncStart))
 	if generateBars {
-		generateNSaveBars(ticker, dir, trades)
+		generateNSaveBars(ticker)
 	}
 	return
 }
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Type of symbols calculated from the bars of other symbols
+	symbolTypeSynthetic = "synthetic"
+	// Exchange of synthetic symbols. Tickers are prefixed with it. Eg: "Synthetic:HALO/USDT"
+	syntheticExchange = "Synthetic"
+)
+
+// SyntheticPair describes a pair that is not traded directly, but calculated by
+// chaining the prices of traded pairs. Eg: HALO/USDT = HALO/ETH * ETH/USDT
+type SyntheticPair struct {
+	// Pair name. Eg: "HALO/USDT"
+	Name string `json:"name"`
+	// Default: "Synthetic <Name>"
+	Description string `json:"description"`
+	// Pairs of which the prices are multiplied, starting with the quote token of the pair
+	// and ending with the base token. Eg: HALO/ETH and ETH/USDT for HALO/USDT.
+	// If empty, the inverse of the pair BASE/QUOTE is used. Eg: HALO/ETH for ETH/HALO.
+	Legs []SyntheticLeg `json:"legs"`
+}
+
+// SyntheticLeg is a traded pair used to calculate a synthetic pair
+type SyntheticLeg struct {
+	// Symbol name or ticker. Eg: "HALO/ETH" or "Other:HALO/ETH"
+	Symbol string `json:"symbol"`
+	// Use the inverse price (1/price) of the symbol. Eg: HALO/ETH as ETH/HALO
+	Inverse bool `json:"inverse"`
+}
+
+// setupSyntheticPairs validates the synthetic pairs configuration.
+// Legs are resolved once the symbols of the trade sources are available.
+func setupSyntheticPairs() error {
+	names := map[string]bool{}
+	for i, p := range conf.SyntheticPairs {
+		tokens := strings.Split(p.Name, "/")
+		if len(tokens) != 2 || strings.TrimSpace(tokens[0]) == "" || strings.TrimSpace(tokens[1]) == "" {
+			return fmt.Errorf("syntheticpairs[%d]: invalid name: %q. Expected format: QUOTE/BASE", i, p.Name)
+		}
+		if names[strings.ToLower(p.Name)] {
+			return fmt.Errorf("syntheticpairs[%d]: duplicate name: %s", i, p.Name)
+		}
+		names[strings.ToLower(p.Name)] = true
+		for j, leg := range p.Legs {
+			if strings.TrimSpace(leg.Symbol) == "" {
+				return fmt.Errorf("syntheticpairs[%d].legs[%d]: symbol is required", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// newSyntheticSymbols creates the symbols of the synthetic pairs.
+// Expects the symbols of the legs to be set. Pairs with legs that are not available are skipped.
+func newSyntheticSymbols(pairs []SyntheticPair) (list []Symbol) {
+	for _, p := range pairs {
+		legs, err := resolveSyntheticLegs(p)
+		if err != nil {
+			log.Println("Skipping synthetic pair", p.Name, err)
+			continue
+		}
+		description := p.Description
+		if description == "" {
+			description = "Synthetic " + p.Name
+		}
+		tokens := strings.Split(p.Name, "/")
+		s := newSymbol(
+			syntheticExchange,
+			p.Name,
+			syntheticExchange+":"+p.Name,
+			description,
+			"", "",
+			tokens[1])
+		s.Type = symbolTypeSynthetic
+		s.legs = legs
+		// Bars are aligned to the calendar of the legs
+		first, _ := symbols.Get(legs[0].Symbol)
+		s.setCalendar(first.Session, first.TimeZone, first.location)
+		list = append(list, s)
+		log.Println("Adding synthetic pair: ", s.Ticker, legs)
+	}
+	return
+}
+
+// resolveSyntheticLegs finds the symbols of the legs and checks if they form a chain from
+// the quote token to the base token of the pair. Leg symbols are replaced by their tickers.
+func resolveSyntheticLegs(p SyntheticPair) (legs []SyntheticLeg, err error) {
+	tokens := strings.Split(p.Name, "/")
+	quote, base := tokens[0], tokens[1]
+	legs = p.Legs
+	if len(legs) == 0 {
+		legs = []SyntheticLeg{{Symbol: base + "/" + quote, Inverse: true}}
+	}
+	result := []SyntheticLeg{}
+	chain := quote
+	timeZone := ""
+	for _, leg := range legs {
+		symbol, found := findSymbol(leg.Symbol)
+		if !found || symbol.isSynthetic() {
+			return nil, fmt.Errorf("symbol not found: %s", leg.Symbol)
+		}
+		if timeZone != "" && symbol.TimeZone != timeZone {
+			return nil, fmt.Errorf("legs must have the same timezone: %s", leg.Symbol)
+		}
+		timeZone = symbol.TimeZone
+		from, to := symbol.market().QuoteTicker, symbol.BaseTicker
+		if leg.Inverse {
+			from, to = to, from
+		}
+		if !strings.EqualFold(from, chain) {
+			return nil, fmt.Errorf("%s does not continue the chain at %s", leg.Symbol, chain)
+		}
+		chain = to
+		result = append(result, SyntheticLeg{Symbol: strings.ToLower(symbol.Ticker), Inverse: leg.Inverse})
+	}
+	if !strings.EqualFold(chain, base) {
+		return nil, fmt.Errorf("legs end with %s instead of %s", chain, base)
+	}
+	return result, nil
+}
+
+// setCalendar sets the session and timezone of a symbol of which the bars are not generated from it's own trades
+func (s *Symbol) setCalendar(session, timeZone string, location *time.Location) {
+	s.Session = session
+	s.TimeZone = timeZone
+	s.location = location
+	loc := s.Location()
+	s.HasDaily = isAvailableResolution("1D", loc)
+	s.HasWeeklyAndMonthly = isAvailableResolution("1W", loc) && isAvailableResolution("1M", loc)
+}
+
+// isSynthetic checks if the symbol is calculated from other symbols
+func (s Symbol) isSynthetic() bool {
+	return s.Type == symbolTypeSynthetic
+}
+
+// syntheticsOf returns the synthetic symbols using the ticker as a leg
+func syntheticsOf(ticker string) (result []Symbol) {
+	for _, s := range symbols.List() {
+		for _, leg := range s.legs {
+			if strings.EqualFold(leg.Symbol, ticker) {
+				result = append(result, s)
+				break
+			}
+		}
+	}
+	return
+}
+
+// getSyntheticResolution returns all bars of a resolution of a synthetic symbol
+// by combining the bars of it's legs. Bars are cached until the bars of any leg change.
+func getSyntheticResolution(symbol Symbol, resolution string) (bars []Bar, err error) {
+	legBars := make([][]Bar, len(symbol.legs))
+	for i, leg := range symbol.legs {
+		if legBars[i], err = getResolution(leg.Symbol, resolution); err != nil {
+			return
+		}
+	}
+	key := barCacheKey(symbol.Ticker, resolution)
+	if bars, found := derivedBars.Get(key, legBars...); found {
+		return bars, nil
+	}
+	bars = combineLegBars(symbol.legs, legBars)
+	if symbol.FillsGaps(resolution) {
+		target, _ := parseResolution(resolution)
+		bars = fillGaps(nil, bars, target.In(symbol.Location()), clock.Now())
+	}
+	derivedBars.Add(key, bars, legBars...)
+	return
+}
+
+// combineLegBars calculates the bars of a synthetic pair from the bars of it's legs, aligned by bar time.
+// Open, high, low and close prices are the products of the leg prices.
+// Legs without a bar at the time of another leg's bar use their last closing price.
+// Bars before every leg has a bar are skipped.
+// Volume is the volume of the first leg in the quote token of the pair.
+// Expects bars to be in ascending order.
+func combineLegBars(legs []SyntheticLeg, legBars [][]Bar) (result []Bar) {
+	times := []int64{}
+	seen := map[int64]bool{}
+	for _, bars := range legBars {
+		for _, b := range bars {
+			if !seen[b.UnixTime] {
+				seen[b.UnixTime] = true
+				times = append(times, b.UnixTime)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	next := make([]int, len(legs))
+	last := make([]*Bar, len(legs))
+	for _, t := range times {
+		var current *Bar
+		bar := Bar{OpeningPrice: 1, HighPrice: 1, LowPrice: 1, ClosingPrice: 1}
+		valid := true
+		for i, bars := range legBars {
+			b := Bar{}
+			if next[i] < len(bars) && bars[next[i]].UnixTime == t {
+				last[i] = &bars[next[i]]
+				next[i]++
+				b = *last[i]
+				if current == nil {
+					current = last[i]
+				}
+			} else if last[i] != nil {
+				// no trades of this leg. Use the last price.
+				price := last[i].ClosingPrice
+				b = Bar{OpeningPrice: price, HighPrice: price, LowPrice: price, ClosingPrice: price}
+			} else {
+				valid = false
+				continue
+			}
+			o, h, l, c := b.OpeningPrice, b.HighPrice, b.LowPrice, b.ClosingPrice
+			if legs[i].Inverse {
+				if o == 0 || h == 0 || l == 0 || c == 0 {
+					valid = false
+					continue
+				}
+				o, h, l, c = 1/o, 1/l, 1/h, 1/c
+			}
+			bar.OpeningPrice *= o
+			bar.HighPrice *= h
+			bar.LowPrice *= l
+			bar.ClosingPrice *= c
+			if i == 0 {
+				bar.Trades = b.Trades
+				bar.Volume = b.Volume
+				if legs[i].Inverse {
+					bar.Volume = b.QuoteVolume
+				}
+			}
+		}
+		if !valid {
+			continue
+		}
+		bar.Time = current.Time
+		bar.TimeEnd = current.TimeEnd
+		bar.UnixTime = current.UnixTime
+		result = append(result, bar)
+	}
+	return
+}
+
+// syntheticBarsSnapshot returns the bars of the stored resolutions of the synthetic symbols using the ticker as a leg.
+// Used to publish the bar updates of synthetic symbols once the bars of the leg are updated.
+func syntheticBarsSnapshot(ticker string) map[string][]Bar {
+	snapshot := map[string][]Bar{}
+	for _, s := range syntheticsOf(ticker) {
+		for _, res := range resolutions {
+			bars, err := getResolution(s.Ticker, res)
+			if err == nil {
+				snapshot[barCacheKey(s.Ticker, res)] = bars
+			}
+		}
+	}
+	return snapshot
+}
+
+// publishSyntheticUpdates publishes the bar updates of the synthetic symbols using the ticker as a leg
+func publishSyntheticUpdates(ticker string, prev map[string][]Bar) {
+	for _, s := range syntheticsOf(ticker) {
+		for _, res := range resolutions {
+			bars, err := getResolution(s.Ticker, res)
+			if err != nil {
+				log.Println("Failed to generate synthetic bars", s.Ticker, res, err)
+				continue
+			}
+			publishBarUpdates(strings.ToLower(s.Ticker), res, prev[barCacheKey(s.Ticker, res)], bars)
+		}
+	}
+}
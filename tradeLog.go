@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+const tradeLogFileName = "trades.log"
+const legacyTradesFileName = "trades.json"
+
+// tradeLog is an append-only trade storage of a single pair.
+// Trades are stored in chronological order, one JSON encoded trade per line.
+// Every append is fsync'd and a partially written last line (eg: after a crash) is discarded on open.
+type tradeLog struct {
+	filename string
+	// set if a failed append could not be undone. Repaired before the next append.
+	dirty bool
+}
+
+// openTradeLog opens the trade log in dir. Creates the directory if necessary,
+// migrates the legacy trades.json file and repairs an incomplete last line.
+func openTradeLog(dir string) (l *tradeLog, err error) {
+	// makes sure file path exists when saving file
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	l = &tradeLog{filename: dir + "/" + tradeLogFileName}
+	if err = migrateLegacyTrades(dir, l.filename); err != nil {
+		return
+	}
+	return l, l.repair()
+}
+
+// repair truncates everything after the last complete line
+func (l *tradeLog) repair() (err error) {
+	f, err := os.OpenFile(l.filename, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	line, offset, err := lastLine(f)
+	if err != nil {
+		return
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return
+	}
+	end := int64(0)
+	if line != nil {
+		end = offset + int64(len(line)) + 1
+	}
+	if end >= stat.Size() {
+		return
+	}
+	log.Printf("Trade log %s: discarding %d bytes of incomplete data", l.filename, stat.Size()-end)
+	if err = f.Truncate(end); err != nil {
+		return
+	}
+	return f.Sync()
+}
+
+// Append writes trades to the end of the log and flushes them to the disk.
+// If writing fails, the log is truncated to it's previous size, to not leave a partial line
+// behind for the next append to continue.
+// Expects trades to be in decending order (as retrieved from DEX).
+func (l *tradeLog) Append(trades []client.Trade) (err error) {
+	if len(trades) == 0 {
+		return
+	}
+	if l.dirty {
+		if err = l.repair(); err != nil {
+			return
+		}
+		l.dirty = false
+	}
+	buf := bytes.Buffer{}
+	for i := len(trades) - 1; i >= 0; i-- {
+		b, err := json.Marshal(trades[i])
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	f, err := os.OpenFile(l.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		// discard the partially written trades
+		if truncErr := f.Truncate(stat.Size()); truncErr != nil {
+			log.Printf("Trade log %s: failed to discard incomplete data: %v", l.filename, truncErr)
+			l.dirty = true
+		}
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
+// LastTradeTime returns the time of the latest trade. Zero if log is empty.
+func (l *tradeLog) LastTradeTime() (t time.Time, err error) {
+	f, err := os.Open(l.filename)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	line, _, err := lastLine(f)
+	if err != nil || line == nil {
+		return
+	}
+	trade := client.Trade{}
+	err = json.Unmarshal(line, &trade)
+	return trade.Time, err
+}
+
+// ReadSince returns all trades on or after the given time in decending order.
+// Use zero time to read all trades.
+func (l *tradeLog) ReadSince(since time.Time) ([]client.Trade, error) {
+	return l.ReadBetween(since, maxTradeTime)
+}
+
+// ReadBetween returns the trades within the time range (inclusive) in decending order.
+// Reading starts at the first trade since then, found by a binary search, and stops after until.
+func (l *tradeLog) ReadBetween(since, until time.Time) (trades []client.Trade, err error) {
+	f, err := os.Open(l.filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if !since.IsZero() {
+		offset, err := seekTrade(f, since)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		trade := client.Trade{}
+		if err = json.Unmarshal(scanner.Bytes(), &trade); err != nil {
+			return
+		}
+		if trade.Time.Before(since) {
+			continue
+		}
+		if trade.Time.After(until) {
+			break
+		}
+		trades = append(trades, trade)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	// reverse to decending order
+	for i, j := 0, len(trades)-1; i < j; i, j = i+1, j-1 {
+		trades[i], trades[j] = trades[j], trades[i]
+	}
+	return
+}
+
+// seekTrade returns the offset of the first line of which the trade is on or after t.
+// Returns the file size if there is none.
+// Trades are in chronological order, so it is found by a binary search over the file offsets.
+func seekTrade(f *os.File, t time.Time) (offset int64, err error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return
+	}
+	size := stat.Size()
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := lineAt(f, mid, size)
+		if err != nil {
+			return 0, err
+		}
+		if line != nil {
+			trade := client.Trade{}
+			if err = json.Unmarshal(line, &trade); err != nil {
+				return 0, err
+			}
+			if trade.Time.Before(t) {
+				// the line and everything before it
+				lo = start + int64(len(line)) + 1
+				continue
+			}
+		}
+		hi = mid
+	}
+	offset, _, err = lineAt(f, lo, size)
+	return
+}
+
+// lineAt returns the first complete (new line terminated) line starting on or after pos and it's offset.
+// Returns nil and the size if there is none.
+func lineAt(f *os.File, pos, size int64) (offset int64, line []byte, err error) {
+	offset = pos
+	if pos > 0 {
+		// start at the previous character to tell if a line starts at pos
+		offset--
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, offset, size-offset))
+	if pos > 0 {
+		var skipped []byte
+		if skipped, err = r.ReadBytes('\n'); err != nil {
+			return endOfLog(size, err)
+		}
+		offset += int64(len(skipped))
+	}
+	if line, err = r.ReadBytes('\n'); err != nil {
+		return endOfLog(size, err)
+	}
+	return offset, line[:len(line)-1], nil
+}
+
+// endOfLog returns the size as the offset, if the end of the file was reached before the end of a line
+func endOfLog(size int64, err error) (int64, []byte, error) {
+	if err == io.EOF {
+		err = nil
+	}
+	return size, nil, err
+}
+
+// lastLine returns the last complete (new line terminated) line and it's offset.
+// Returns nil if there is no complete line.
+func lastLine(f *os.File) (line []byte, offset int64, err error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return
+	}
+	const chunkSize = 4096
+	end := stat.Size()
+	buf := []byte{}
+	for pos := end; pos > 0; {
+		n := int64(chunkSize)
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		chunk := make([]byte, n)
+		if _, err = f.ReadAt(chunk, pos); err != nil && err != io.EOF {
+			return
+		}
+		err = nil
+		buf = append(chunk, buf...)
+		// ignore everything after the last new line character
+		lastNL := bytes.LastIndexByte(buf, '\n')
+		if lastNL < 0 {
+			continue
+		}
+		prevNL := bytes.LastIndexByte(buf[:lastNL], '\n')
+		if prevNL < 0 && pos > 0 {
+			continue
+		}
+		return buf[prevNL+1 : lastNL], pos + int64(prevNL+1), nil
+	}
+	return
+}
+
+// migrateLegacyTrades converts the trades.json file (array of trades in decending order)
+// to the trade log, only if trade log does not already exist.
+// The legacy file is kept as "trades.json.migrated".
+func migrateLegacyTrades(dir, logFile string) (err error) {
+	legacyFile := dir + "/" + legacyTradesFileName
+	if _, err = os.Stat(logFile); err == nil || !os.IsNotExist(err) {
+		return
+	}
+	if _, err = os.Stat(legacyFile); os.IsNotExist(err) {
+		return nil
+	}
+	log.Println("Migrating trades to trade log:", legacyFile)
+	txt, err := client.ReadFile(legacyFile)
+	if err != nil {
+		return
+	}
+	trades := []client.Trade{}
+	if err = json.Unmarshal([]byte(txt), &trades); err != nil {
+		return
+	}
+	tmpLog := &tradeLog{filename: logFile + ".tmp"}
+	// create (or empty) the file in case there are no trades
+	f, err := os.Create(tmpLog.filename)
+	if err != nil {
+		return
+	}
+	f.Close()
+	if err = tmpLog.Append(trades); err != nil {
+		return
+	}
+	if err = os.Rename(tmpLog.filename, logFile); err != nil {
+		return
+	}
+	if err = os.Rename(legacyFile, legacyFile+".migrated"); err != nil {
+		return
+	}
+	log.Printf("Migrated %d trades: %s", len(trades), logFile)
+	return syncDir(dir)
+}
+
+// syncDir flushes directory entries (eg: renamed files) to the disk
+func syncDir(dir string) (err error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+func TestTradeLogAppendAfterFailedAppend(t *testing.T) {
+	l, err := openTradeLog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err = l.Append([]client.Trade{{Price: 1, Amount: 10, Time: t0}}); err != nil {
+		t.Fatal(err)
+	}
+	// a failed append which could not be undone leaves a partial line behind
+	f, err := os.OpenFile(l.filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Amount":5,"Pri`)
+	f.Close()
+	l.dirty = true
+
+	if err = l.Append([]client.Trade{{Price: 2, Amount: 20, Time: t0.Add(time.Minute)}}); err != nil {
+		t.Fatal(err)
+	}
+	trades, err := l.ReadSince(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 2 || trades[0].Price != 2 || trades[1].Price != 1 {
+		t.Fatalf("unexpected trades: %+v", trades)
+	}
+}
+
+func TestTradeLogReadBetween(t *testing.T) {
+	l, err := openTradeLog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	all := []client.Trade{} // decending order
+	for i := 0; i < 200; i++ {
+		// two trades at each minute
+		all = append([]client.Trade{{Price: float64(i), Amount: 1, Time: t0.Add(time.Duration(i/2) * time.Minute)}}, all...)
+	}
+	if err = l.Append(all); err != nil {
+		t.Fatal(err)
+	}
+	ranges := [][2]time.Time{
+		{time.Time{}, maxTradeTime},
+		{t0, t0},
+		{t0.Add(-time.Hour), t0.Add(-time.Minute)},
+		{t0.Add(30 * time.Second), t0.Add(10 * time.Minute)},
+		{t0.Add(57 * time.Minute), t0.Add(57 * time.Minute)},
+		{t0.Add(99 * time.Minute), maxTradeTime},
+		{t0.Add(100 * time.Minute), maxTradeTime},
+	}
+	for _, r := range ranges {
+		want := []client.Trade{}
+		for _, trade := range all {
+			if !trade.Time.Before(r[0]) && !trade.Time.After(r[1]) {
+				want = append(want, trade)
+			}
+		}
+		got, err := l.ReadBetween(r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) || (len(got) > 0 && (got[0].Price != want[0].Price || got[len(got)-1].Price != want[len(want)-1].Price)) {
+			t.Errorf("%s - %s: unexpected trades: %+v", r[0], r[1], got)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alien45/halo-info-bot/client"
+)
+
+// Trade source types
+const sourceTypeHaloDEX = "halodex"
+
+// TradeSource provides markets and trades of a single venue
+type TradeSource interface {
+	// ListMarkets returns all tradable pairs
+	ListMarkets() ([]Market, error)
+	// FetchTradesSince returns trades of the market on or after since in decending order
+	FetchTradesSince(market Market, since time.Time) ([]client.Trade, error)
+}
+
+// OrderBookSource is implemented by trade sources which also provide open orders
+type OrderBookSource interface {
+	// FetchOrderBook returns up to depth best bids (highest first) and asks (lowest first) of the market.
+	// All orders are returned if depth is 0.
+	FetchOrderBook(market Market, depth int) (OrderBook, error)
+}
+
+// OrderBook contains the open orders of a market
+type OrderBook struct {
+	Bids []Order `json:"bids"`
+	Asks []Order `json:"asks"`
+}
+
+// Order is a price level of an order book
+type Order struct {
+	Price  float64 `json:"price"`
+	Amount float64 `json:"amount"`
+}
+
+// Market describes a tradable pair of a TradeSource
+type Market struct {
+	QuoteTicker  string
+	QuoteName    string
+	QuoteAddress string
+	BaseTicker   string
+	BaseAddress  string
+}
+
+// SourceConfig describes a named trade source
+type SourceConfig struct {
+	// Name of the exchange. Displayed on the charts.
+	Name string `json:"name"`
+	// Type of the source. Supported types: "halodex", "fixture"
+	Type string `json:"type"`
+	// Only for "halodex" type
+	HaloDEX client.DEX `json:"halodex"`
+	// Only for "fixture" type. Directory of the fixture files.
+	Path string `json:"path"`
+}
+
+// sourceTypes contains the constructors of all supported trade source types
+var sourceTypes = map[string]func(c SourceConfig) (TradeSource, error){
+	sourceTypeHaloDEX: func(c SourceConfig) (TradeSource, error) {
+		return &haloDEXSource{dex: c.HaloDEX}, nil
+	},
+}
+
+var sources map[string]TradeSource // lowercase source name : TradeSource
+var sourceNames []string           // source names in the order of configuration
+
+// setupSources instantiates all configured trade sources.
+// If none configured, HaloDEX is used as the only source.
+func setupSources() (err error) {
+	configs := conf.Sources
+	if len(configs) == 0 {
+		configs = []SourceConfig{{Name: "HaloDEX", Type: sourceTypeHaloDEX, HaloDEX: conf.HaloDEX}}
+	}
+	sources = map[string]TradeSource{}
+	sourceNames = []string{}
+	for _, c := range configs {
+		newSource, found := sourceTypes[strings.ToLower(c.Type)]
+		if !found {
+			return fmt.Errorf("Unsupported source type: %s", c.Type)
+		}
+		key := strings.ToLower(c.Name)
+		if key == "" || sources[key] != nil {
+			return fmt.Errorf("Source name must be unique and not empty: %q", c.Name)
+		}
+		if sources[key], err = newSource(c); err != nil {
+			return
+		}
+		sourceNames = append(sourceNames, c.Name)
+	}
+	return
+}
+
+// getSource returns the trade source by exchange name
+func getSource(exchange string) (source TradeSource, found bool) {
+	source, found = sources[strings.ToLower(exchange)]
+	return
+}
+
+// haloDEXSource is a TradeSource adapter of the HaloDEX client
+type haloDEXSource struct {
+	dex client.DEX
+}
+
+// ListMarkets pairs every quote token with every base token, ordered by ticker
+func (s *haloDEXSource) ListMarkets() (markets []Market, err error) {
+	tokenMap, err := s.dex.GetTokens()
+	if err != nil {
+		return
+	}
+	tokens := []client.Token{}
+	for _, token := range tokenMap {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Ticker < tokens[j].Ticker })
+	baseTokens := []client.Token{}
+	quoteTokens := []client.Token{}
+	for _, token := range tokens {
+		if token.Type == "BASE" {
+			baseTokens = append(baseTokens, token)
+			continue
+		}
+		quoteTokens = append(quoteTokens, token)
+	}
+	for _, baseT := range baseTokens {
+		for _, quoteT := range quoteTokens {
+			markets = append(markets, Market{
+				QuoteTicker:  strings.ToUpper(quoteT.Ticker),
+				QuoteName:    quoteT.Name,
+				QuoteAddress: quoteT.HaloChainAddress,
+				BaseTicker:   strings.ToUpper(baseT.Ticker),
+				BaseAddress:  baseT.HaloChainAddress,
+			})
+		}
+	}
+	return
+}
+
+func (s *haloDEXSource) FetchTradesSince(market Market, since time.Time) ([]client.Trade, error) {
+	return s.dex.GetTradesByTime(market.QuoteAddress, market.BaseAddress, since)
+}